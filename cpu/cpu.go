@@ -1,11 +1,13 @@
 package cpu
 
 import (
-	"github.com/patrickmn/go-cache"
+	"github.com/zero-os/0-ork/registry"
 	"sort"
 )
 
 type CPU interface {
+	registry.Activity
+	// CPU returns the cpu time consumed by the activity in nanoseconds per second
 	CPU() float64
 	Kill() error
 	Name() string
@@ -23,15 +25,15 @@ func (a Activities) Less(i, j int) bool {
 	return a[i].CPU() < a[j].CPU()
 }
 
-func GetCPUActivities(c *cache.Cache) Activities {
-	items := c.Items()
-	activities := make(Activities, 0, c.ItemCount())
+func GetCPUActivities(c *registry.Registry) Activities {
+	activities := make(Activities, 0, c.Len())
 
-	for _, item := range items {
-		if activity, ok := item.Object.(CPU); ok {
+	c.Range(func(value interface{}) bool {
+		if activity, ok := value.(CPU); ok {
 			activities = append(activities, activity)
 		}
-	}
+		return true
+	})
 	sort.Sort(sort.Reverse(activities))
 	return activities
 }
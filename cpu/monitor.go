@@ -2,65 +2,220 @@
 package cpu
 
 import (
-	"github.com/VividCortex/ewma"
+	"context"
+	"runtime"
+	"time"
+
 	"github.com/op/go-logging"
-	"github.com/patrickmn/go-cache"
-	ps_cpu "github.com/shirou/gopsutil/cpu"
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/monitor"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
 )
 
 const cpuThreshold float64 = 90.0 // cpuThreshold holds the percentage of cpu consumption at which ork should kill activities
+
+// settleDelay is the time given to the system to settle after a kill before the cpu consumption is measured again
+const settleDelay = 2 * time.Second
+
+// sampleWindow is the window over which the instantaneous cpu consumption is measured
+const sampleWindow = 500 * time.Millisecond
+
+// maxKills is the number of activities killed in a single run, the next run kills more if needed
+const maxKills = 10
+
+// deadline is the time after which a run is considered stalled by the watchdog, it leaves room for maxKills
+// kills each measured before and after settleDelay.
+const deadline = time.Minute + maxKills*(settleDelay+2*sampleWindow)
+
 var log = logging.MustGetLogger("ORK")
-var cpuEwma = ewma.NewMovingAverage(60)
+
+// cpuEwma tracks the cpu consumption of all tasks and normalEwma the consumption of the tasks that are not niced
+var cpuEwma = utils.NewAverage(60*time.Second, 10)
+var normalEwma = utils.NewAverage(60*time.Second, 10)
+var lastTimes *host.CPUTimes
 var killCounter = 0
+var totalCpus = float64(runtime.NumCPU())
+
+// sleep waits while the cpu consumption is measured and while the system settles after a kill, it is only
+// replaced by tests to make the cpu consumption change while they wait
+var sleep = time.Sleep
+
+// cpuTimes returns the cpu times of all cpus combined
+func cpuTimes() (host.CPUTimes, error) {
+	times, err := host.ReadCPUTimes()
+	if err != nil {
+		log.Error("Error getting cpu times")
+		return host.CPUTimes{}, err
+	}
+	return times, nil
+}
+
+// usage returns the percentage of cpu time spent busy between two cpu times. The time spent running niced tasks
+// is excluded if excludeNice is true.
+func usage(before host.CPUTimes, after host.CPUTimes, excludeNice bool) float64 {
+	total := float64(after.Total()) - float64(before.Total())
+	if total <= 0 {
+		return 0
+	}
+	busy := total - (float64(after.Idle) - float64(before.Idle)) - (float64(after.Iowait) - float64(before.Iowait))
+	if excludeNice {
+		busy -= float64(after.Nice) - float64(before.Nice)
+	}
+	if busy < 0 {
+		return 0
+	}
+	return busy / total * 100
+}
+
+// currentEwma returns the ewma the cpu consumption is checked against. Once activities are throttled the
+// time spent running them doesn't count since it doesn't prevent the other tasks from running.
+func currentEwma() *utils.Average {
+	if len(throttled) > 0 {
+		return normalEwma
+	}
+	return cpuEwma
+}
 
 // isCPUOk returns a true if the CPU consumption is below the defined threshold
 func isCPUOk() (bool, error) {
-	percent, err := ps_cpu.Percent(0, false)
-
+	times, err := cpuTimes()
 	if err != nil {
-		log.Error("Error getting available memory")
 		return false, err
 	}
-	cpuEwma.Add(percent[0])
+	if lastTimes == nil {
+		lastTimes = &times
+		return true, nil
+	}
+	now := time.Now()
+	cpuEwma.Add(now, usage(*lastTimes, times, false))
+	normalEwma.Add(now, usage(*lastTimes, times, true))
+	lastTimes = &times
 
-	if cpuEwma.Value() < cpuThreshold {
+	value := currentEwma().Value()
+	if value < cpuThreshold {
 		killCounter = 0
-		log.Debugf("CPU consumption is below threshold: %v", cpuEwma.Value())
+		log.Debugf("CPU consumption is below threshold: %v", value)
 		return true, nil
 	}
 	killCounter += 1
 
 	if killCounter >= 5 {
-		log.Debugf("CPU consumption is above threshold: %v and kill counter is %v", cpuEwma.Value(), killCounter)
+		log.Debugf("CPU consumption is above threshold: %v and kill counter is %v", value, killCounter)
 		return false, nil
 	}
 
-	log.Debugf("CPU consumption is above threshold: %v and kill counter is %v", cpuEwma.Value(), killCounter)
+	log.Debugf("CPU consumption is above threshold: %v and kill counter is %v", value, killCounter)
 	return true, nil
 }
 
-// Monitor checks the cpu consumption and if it exceeds  cpuThreshold it kills
-// activities until the consumption is bellow the threshold.
-func Monitor(c *cache.Cache) error {
+// instantCPU returns the cpu consumption percentage measured over sampleWindow
+func instantCPU() (float64, error) {
+	before, err := cpuTimes()
+	if err != nil {
+		return 0, err
+	}
+	sleep(sampleWindow)
+	after, err := cpuTimes()
+	if err != nil {
+		return 0, err
+	}
+	return usage(before, after, len(throttled) > 0), nil
+}
+
+// share returns the percentage of the total cpu capacity consumed by an activity
+func share(activ CPU) float64 {
+	return activ.CPU() / float64(time.Second) / totalCpus * 100
+}
+
+// isCPUSettled waits for settleDelay after a kill and returns true if the instantaneous cpu consumption measured
+// afterwards is below the threshold. The projected load is only logged, the decision rests on the measurement.
+// The ewma is reset to the new measurement so the stale samples from before the kill are discarded.
+func isCPUSettled(projected float64) (bool, error) {
+	sleep(settleDelay)
+
+	percent, err := instantCPU()
+	if err != nil {
+		return false, err
+	}
+	currentEwma().Set(time.Now(), percent)
+
+	if percent < cpuThreshold {
+		log.Debugf("CPU consumption settled: projected %v, measured %v", projected, percent)
+		return true, nil
+	}
+
+	log.Debugf("CPU consumption is still above threshold: projected %v, measured %v", projected, percent)
+	return false, nil
+}
+
+func init() {
+	monitor.Register(monitor.NewWithDeadline("cpu", time.Second, deadline, Monitor))
+}
+
+// Monitor checks the cpu consumption and if it exceeds  cpuThreshold it throttles the top activities first
+// and if that didn't help within throttleWindow it kills activities until the consumption is bellow the threshold.
+// Realtime activities spinning on a cpu are demoted first since they don't show in the overall consumption.
+// Nothing is killed once ctx is done.
+func Monitor(ctx context.Context, c *registry.Registry) error {
 	log.Debug("Monitoring CPU")
 
+	if err := monitorRealtime(ctx, c); err != nil {
+		return err
+	}
+
 	cpuOk, err := isCPUOk()
 	if err != nil {
 		return err
 	}
 	if cpuOk == true {
+		// The overload episode is over once the tasks that are not niced get enough cpu, the throttled
+		// activities keep their priorities until they don't overload the cpu anymore
+		if normalEwma.Value() < cpuThreshold {
+			endEpisode()
+		}
+		if cpuEwma.Value() < cpuThreshold {
+			restoreThrottled()
+		}
 		return nil
 	}
 
 	activities := GetCPUActivities(c)
 
-	for i := 0; i < len(activities) && cpuOk == false; i++ {
+	if throttledAt.IsZero() && throttle(activities) {
+		killCounter = 0
+		return nil
+	}
+	if !throttledAt.IsZero() && time.Since(throttledAt) < throttleWindow {
+		return nil
+	}
+
+	for i := 0; i < len(activities) && i < maxKills && cpuOk == false; i++ {
 		activ := activities[i]
-		if err := activ.Kill(); err == nil {
-			c.Delete(activ.Name())
+
+		load, err := instantCPU()
+		if err != nil {
+			return err
+		}
+		if load < cpuThreshold {
+			log.Debugf("CPU consumption dropped below threshold before killing: %v", load)
+			currentEwma().Set(time.Now(), load)
 			killCounter = 0
+			return nil
+		}
+
+		projected := load - share(activ)
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		if cpuOk, err = isCPUOk(); err != nil {
+		if err := activ.Kill(); err != nil {
+			continue
+		}
+		c.Remove(activ)
+		forget(activ)
+		killCounter = 0
+
+		if cpuOk, err = isCPUSettled(projected); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,351 @@
+package cpu
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/orktest"
+	"github.com/zero-os/0-ork/process"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// activity is a cpu consuming activity that records the actions taken on it
+type activity struct {
+	orktest.Activity
+	cpu    float64
+	nice   int
+	ioNice int
+}
+
+func newActivity(name string, cpu float64) *activity {
+	return &activity{Activity: orktest.Activity{Label: name}, cpu: cpu}
+}
+
+func (a *activity) CPU() float64 { return a.cpu }
+
+func (a *activity) Renice(nice int) error {
+	a.nice = nice
+	return nil
+}
+
+func (a *activity) IoNice(class int, level int) error {
+	a.ioNice = class
+	return nil
+}
+
+func (a *activity) RestorePriority() error {
+	a.nice, a.ioNice = 0, 0
+	return nil
+}
+
+// fakeCPU is a host whose cpus spend a configurable fraction of their time busy
+type fakeCPU struct {
+	*host.Fake
+	busy, idle uint64
+}
+
+func newFakeCPU(t *testing.T) *fakeCPU {
+	f := &fakeCPU{Fake: orktest.NewHost(t, func() {
+		lastTimes = nil
+		killCounter = 0
+		throttled = nil
+		throttledAt = time.Time{}
+		cpuEwma = utils.NewAverage(60*time.Second, 10)
+		normalEwma = utils.NewAverage(60*time.Second, 10)
+	})}
+	f.tick(0)
+	return f
+}
+
+// tick advances the cpu times by 100 ticks of which percent are busy
+func (f *fakeCPU) tick(percent uint64) {
+	f.busy += percent
+	f.idle += 100 - percent
+	f.Set("/proc/stat", fmt.Sprintf("cpu  %v 0 0 %v 0 0 0 0 0 0\nprocs_blocked 0\n", f.busy, f.idle))
+}
+
+func TestMonitorIdle(t *testing.T) {
+	f := newFakeCPU(t)
+	hog := newActivity("hog", float64(time.Second))
+	c := registry.New()
+	c.Set(orktest.Kind, hog.ID(), hog)
+
+	for i := 0; i < 30; i++ {
+		f.tick(50)
+		if err := Monitor(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hog.nice != 0 || hog.Killed {
+		t.Errorf("expected nothing to be done below the threshold, nice %v killed %v", hog.nice, hog.Killed)
+	}
+	if value := cpuEwma.Value(); value != 50 {
+		t.Errorf("expected the cpu consumption to be 50%%, got %v", value)
+	}
+}
+
+func TestMonitorThrottles(t *testing.T) {
+	f := newFakeCPU(t)
+	s := orktest.NewSystem(t, f.Fake)
+	// hog is the top consumer, busy and light follow and idle doesn't consume anything
+	hog := process.FakeProcess{Pid: 100, Name: "hog", Threads: 3}
+	busy := process.FakeProcess{Pid: 200, Name: "busy"}
+	light := process.FakeProcess{Pid: 300, Name: "light"}
+	idle := process.FakeProcess{Pid: 400, Name: "idle"}
+	for _, p := range []process.FakeProcess{hog, busy, light, idle} {
+		s.Start(p)
+	}
+	for tid := 100; tid < 103; tid++ {
+		s.SetNice(tid, 5)
+	}
+	c := registry.New()
+	run := func(checks int) {
+		for i := 0; i < checks; i++ {
+			s.Run(hog.Pid, 100)
+			s.Run(busy.Pid, 50)
+			s.Run(light.Pid, 25)
+			process.UpdateCache(c)
+			f.tick(100)
+			if err := Monitor(context.Background(), c); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// the consumption is checked once the ewma is warm and has to stay above the threshold for 5 checks
+	run(14)
+	if len(throttled) != 0 {
+		t.Fatal("expected no process to be throttled before the consumption stays high")
+	}
+	run(1)
+	for tid := 100; tid < 103; tid++ {
+		if nice, _ := s.Nice(tid); nice != throttleNice {
+			t.Errorf("expected thread %v of hog to be reniced to %v, got %v", tid, throttleNice, nice)
+		}
+	}
+	for _, p := range []process.FakeProcess{hog, busy, light} {
+		if class, _, _ := s.IoPriority(int(p.Pid)); class != utils.IoprioClassIdle {
+			t.Errorf("expected %v to be moved to the idle I/O class, got %v", p.Name, class)
+		}
+	}
+	if nice, _ := s.Nice(int(idle.Pid)); nice != 0 {
+		t.Errorf("expected only the 3 top processes to be throttled, idle reniced to %v", nice)
+	}
+
+	// processes are not killed while throttling is given time to work
+	run(10)
+	for _, p := range []process.FakeProcess{hog, busy, light, idle} {
+		if s.Killed(p.Pid) {
+			t.Errorf("expected %v not to be killed within the throttle window", p.Name)
+		}
+	}
+
+	// the priorities of each thread are restored once the consumption is back to normal
+	cpuEwma.Set(time.Now(), 10)
+	normalEwma.Set(time.Now(), 10)
+	process.UpdateCache(c)
+	f.tick(10)
+	if err := Monitor(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	for tid := 100; tid < 103; tid++ {
+		if nice, _ := s.Nice(tid); nice != 5 {
+			t.Errorf("expected the niceness of thread %v of hog to be restored to 5, got %v", tid, nice)
+		}
+	}
+	if class, _, _ := s.IoPriority(int(busy.Pid)); class != 0 {
+		t.Errorf("expected the I/O class of busy to be restored, got %v", class)
+	}
+}
+
+func TestThrottleEpisodes(t *testing.T) {
+	f := newFakeCPU(t)
+	hog := newActivity("hog", float64(time.Second))
+	other := newActivity("other", float64(time.Second)/2)
+	c := registry.New()
+	c.Set(orktest.Kind, hog.ID(), hog)
+	c.Set(orktest.Kind, other.ID(), other)
+
+	for i := 0; i < 15; i++ {
+		f.tick(100)
+		if err := Monitor(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hog.nice != throttleNice || other.nice != throttleNice {
+		t.Fatalf("expected the activities to be throttled, nice hog %v other %v", hog.nice, other.nice)
+	}
+
+	// the niced activities keep the cpu busy but the other tasks get enough cpu, which ends the episode
+	normalEwma.Set(time.Now(), 10)
+	f.tick(100)
+	if err := Monitor(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	if !throttledAt.IsZero() || hog.nice != throttleNice {
+		t.Fatalf("expected the episode to end with the activities still throttled, nice hog %v", hog.nice)
+	}
+
+	// a new episode throttles the new top activity before killing anything
+	third := newActivity("third", float64(time.Second)/4)
+	c.Set(orktest.Kind, third.ID(), third)
+	normalEwma.Set(time.Now(), 100)
+	for i := 0; i < 5; i++ {
+		f.tick(100)
+		if err := Monitor(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if third.nice != throttleNice {
+		t.Errorf("expected the new top activity to be throttled in the new episode, nice %v", third.nice)
+	}
+	if hog.Killed || other.Killed || third.Killed {
+		t.Error("expected no activity to be killed within the throttle window of the new episode")
+	}
+}
+
+func TestMonitorStopsOnceSettled(t *testing.T) {
+	f := newFakeCPU(t)
+	// the cpus are busy at load percent during each wait of the monitor
+	load := uint64(100)
+	sleep = func(time.Duration) { f.tick(load) }
+	t.Cleanup(func() { sleep = time.Sleep })
+	hog := newActivity("hog", float64(time.Second))
+	hog.OnKill = func() { load = 40 }
+	other := newActivity("other", float64(time.Second)/2)
+	c := registry.New()
+	c.Set(orktest.Kind, hog.ID(), hog)
+	c.Set(orktest.Kind, other.ID(), other)
+
+	for i := 0; i < 15; i++ {
+		f.tick(100)
+		if err := Monitor(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hog.nice != throttleNice || hog.Killed || other.Killed {
+		t.Fatalf("expected the activities to be throttled first, nice hog %v", hog.nice)
+	}
+
+	// once throttling didn't help, killing hog brings the load below the threshold, which is measured again
+	// before killing other
+	throttledAt = time.Now().Add(-throttleWindow)
+	for i := 0; i < 5; i++ {
+		f.tick(100)
+		if err := Monitor(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !hog.Killed || other.Killed {
+		t.Errorf("expected only hog to be killed, killed hog %v other %v", hog.Killed, other.Killed)
+	}
+	if value := currentEwma().Value(); value != 40 {
+		t.Errorf("expected the cpu consumption to be reset to the settled load of 40%%, got %v", value)
+	}
+}
+
+// realtimeActivity is a realtime activity whose id tells apart the processes that reused the same pid
+type realtimeActivity struct {
+	*activity
+	id      string
+	demoted bool
+}
+
+func (a *realtimeActivity) ID() string         { return a.id }
+func (a *realtimeActivity) RecentCPU() float64 { return a.cpu }
+func (a *realtimeActivity) Realtime() bool     { return !a.demoted }
+func (a *realtimeActivity) Demote() error      { a.demoted = true; return nil }
+
+func TestRealtimePidReuse(t *testing.T) {
+	newFakeCPU(t)
+	t.Cleanup(func() {
+		spinning = make(map[string]time.Time)
+		demoted = make(map[string]struct{})
+	})
+	c := registry.New()
+
+	// spin runs the realtime check on activ as if it has been spinning for rtTimeout
+	spin := func(activ *realtimeActivity) {
+		c.Set(orktest.Kind, activ.id, activ)
+		spinning[activ.id] = time.Now().Add(-rtTimeout)
+		if err := monitorRealtime(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := &realtimeActivity{activity: newActivity("42", float64(time.Second)), id: "42:100"}
+	spin(old)
+	if !old.demoted {
+		t.Fatal("expected the spinning activity to be demoted")
+	}
+	c.Remove(old)
+
+	reused := &realtimeActivity{activity: newActivity("42", float64(time.Second)), id: "42:200"}
+	spin(reused)
+	if !reused.demoted || reused.Killed {
+		t.Errorf("expected a process that reused the pid to be demoted first, demoted %v killed %v", reused.demoted, reused.Killed)
+	}
+
+	reused.demoted = false
+	spin(reused)
+	if !reused.Killed {
+		t.Error("expected a demoted activity spinning again with a realtime policy to be killed")
+	}
+}
+
+func TestRealtimeSpinning(t *testing.T) {
+	f := newFakeCPU(t)
+	s := orktest.NewSystem(t, f.Fake)
+	t.Cleanup(func() {
+		spinning = make(map[string]time.Time)
+		demoted = make(map[string]struct{})
+	})
+	// rt idled long enough for its average consumption to stay low for a while once both of its threads spin
+	rt := process.FakeProcess{Pid: 500, Name: "rt", Threads: 2, Policy: utils.SchedFIFO}
+	s.Start(rt)
+	c := registry.New()
+	for i := 0; i < 20; i++ {
+		process.UpdateCache(c)
+	}
+
+	start := time.Now()
+	last := start
+	for s.Policy(int(rt.Pid)) != utils.SchedOther && time.Since(start) < 2*rtTimeout {
+		time.Sleep(rtTimeout / 20)
+		now := time.Now()
+		s.RunFor(rt.Pid, 2*now.Sub(last))
+		last = now
+		process.UpdateCache(c)
+		if err := monitorRealtime(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > rtTimeout+time.Second {
+		t.Fatalf("expected the spinning process to be demoted within %v, took %v", rtTimeout, elapsed)
+	}
+	for tid := 500; tid < 502; tid++ {
+		if policy := s.Policy(tid); policy != utils.SchedOther {
+			t.Errorf("expected thread %v to be demoted to the normal policy, got %v", tid, policy)
+		}
+	}
+
+	// the process is forgotten once it left the registry
+	var collected []registry.Activity
+	c.Range(func(value interface{}) bool {
+		collected = append(collected, value.(registry.Activity))
+		return true
+	})
+	for _, activ := range collected {
+		c.Remove(activ)
+	}
+	if err := monitorRealtime(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	if len(demoted) != 0 {
+		t.Errorf("expected the demoted processes that left the registry to be forgotten, got %v", demoted)
+	}
+}
@@ -0,0 +1,106 @@
+package cpu
+
+import (
+	"context"
+	"time"
+
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// rtThreshold is the fraction of a cpu a realtime activity has to consume to be considered spinning
+const rtThreshold float64 = 0.95
+
+// rtTimeout is the time a realtime activity can spin before it is demoted
+const rtTimeout = 5 * time.Second
+
+type Realtime interface {
+	registry.Activity
+	RecentCPU() float64
+	Realtime() bool
+	Demote() error
+	Kill() error
+	Name() string
+}
+
+// spinning holds the time each realtime activity started spinning keyed by the activity id
+var spinning = make(map[string]time.Time)
+
+// demoted holds the ids of the activities that were demoted, an id is forgotten once its activity left the registry
+var demoted = make(map[string]struct{})
+
+func GetRealtimeActivities(c *registry.Registry) []Realtime {
+	activities := make([]Realtime, 0)
+
+	c.Range(func(value interface{}) bool {
+		if activity, ok := value.(Realtime); ok && activity.Realtime() {
+			activities = append(activities, activity)
+		}
+		return true
+	})
+	return activities
+}
+
+// monitorRealtime demotes the realtime activities spinning on a cpu for longer than rtTimeout to the normal
+// scheduling policy, they are then handled as any other activity. An activity that is spinning again with a
+// realtime policy after it was demoted is killed. The consumption is the one since the previous scan so that
+// a spinning activity is detected right away rather than once it dominates a long average.
+func monitorRealtime(ctx context.Context, c *registry.Registry) error {
+	now := time.Now()
+	current := make(map[string]time.Time)
+	pruneDemoted(c)
+
+	for _, activ := range GetRealtimeActivities(c) {
+		if activ.RecentCPU() < rtThreshold*float64(time.Second) {
+			continue
+		}
+		start, ok := spinning[activ.ID()]
+		if !ok {
+			start = now
+		}
+		current[activ.ID()] = start
+		if now.Sub(start) < rtTimeout {
+			continue
+		}
+
+		log.Debugf("Realtime activity %v has been spinning for %v", activ.Name(), now.Sub(start))
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, ok := demoted[activ.ID()]; !ok {
+			demoted[activ.ID()] = struct{}{}
+			delete(current, activ.ID())
+			if err := activ.Demote(); err != nil {
+				utils.LogEvent(utils.RealtimeDemote, activ.Name(), utils.Error)
+				continue
+			}
+			utils.LogEvent(utils.RealtimeDemote, activ.Name(), utils.Success)
+			continue
+		}
+
+		if err := activ.Kill(); err == nil {
+			c.Remove(activ)
+			delete(current, activ.ID())
+			delete(demoted, activ.ID())
+		}
+	}
+	spinning = current
+	return nil
+}
+
+// pruneDemoted forgets the demoted activities that are no longer in the registry
+func pruneDemoted(c *registry.Registry) {
+	if len(demoted) == 0 {
+		return
+	}
+	present := make(map[string]struct{}, len(demoted))
+	c.Range(func(value interface{}) bool {
+		if activity, ok := value.(registry.Activity); ok {
+			if _, ok := demoted[activity.ID()]; ok {
+				present[activity.ID()] = struct{}{}
+			}
+		}
+		return true
+	})
+	demoted = present
+}
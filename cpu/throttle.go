@@ -0,0 +1,99 @@
+package cpu
+
+import (
+	"time"
+
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// throttleCount is the number of top activities throttled before killing anything
+const throttleCount = 3
+
+// throttleWindow is the time given to throttling to bring the cpu consumption below the threshold
+const throttleWindow = 10 * time.Second
+
+// throttleNice is the niceness of throttled activities
+const throttleNice = 19
+
+type Throttle interface {
+	registry.Activity
+	Renice(int) error
+	IoNice(int, int) error
+	RestorePriority() error
+	Name() string
+}
+
+// throttled holds the activities that were throttled until their priorities are restored, and throttledAt the
+// time activities were throttled during the current overload episode, it is zero if none was
+var throttled []Throttle
+var throttledAt time.Time
+
+// isThrottled returns true if activ is throttled
+func isThrottled(activ Throttle) bool {
+	for _, t := range throttled {
+		if t.ID() == activ.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+// throttle renices the top activities that are not throttled yet to throttleNice and moves them to the idle I/O
+// class, it returns false if no activity could be throttled.
+func throttle(activities Activities) bool {
+	count := 0
+	for i := 0; i < len(activities) && count < throttleCount; i++ {
+		activ, ok := activities[i].(Throttle)
+		if !ok || isThrottled(activ) {
+			continue
+		}
+		if err := activ.Renice(throttleNice); err != nil {
+			utils.LogEvent(utils.CPUThrottle, activ.Name(), utils.Error)
+			continue
+		}
+		if err := activ.IoNice(utils.IoprioClassIdle, 0); err != nil {
+			log.Errorf("Error setting idle I/O class for %v: %v", activ.Name(), err)
+		}
+		utils.LogEvent(utils.CPUThrottle, activ.Name(), utils.Success)
+		throttled = append(throttled, activ)
+		count++
+	}
+	if count == 0 {
+		return false
+	}
+	throttledAt = time.Now()
+	return true
+}
+
+// endEpisode ends the current overload episode, the throttled activities stay throttled but throttling is tried
+// again on the top activities in the next episode before killing anything.
+func endEpisode() {
+	throttledAt = time.Time{}
+}
+
+// restoreThrottled restores the priorities of the throttled activities, the activities whose priorities failed
+// to be restored stay throttled so that restoring them is retried at the next check
+func restoreThrottled() {
+	var failed []Throttle
+	for _, activ := range throttled {
+		if err := activ.RestorePriority(); err != nil {
+			log.Debugf("Error restoring priority of %v: %v", activ.Name(), err)
+			failed = append(failed, activ)
+			continue
+		}
+		utils.LogEvent(utils.CPUUnThrottle, activ.Name(), utils.Success)
+	}
+	throttled = failed
+	throttledAt = time.Time{}
+}
+
+// forget removes a killed activity from the throttled activities
+func forget(activ CPU) {
+	for i, t := range throttled {
+		if t.ID() == activ.ID() {
+			throttled = append(throttled[:i], throttled[i+1:]...)
+			return
+		}
+	}
+}
@@ -2,18 +2,34 @@ package domain
 
 import (
 	"fmt"
+	"math"
 	"os/exec"
 	"strings"
 	"time"
 
-	libvirt "github.com/libvirt/libvirt-go"
-	"github.com/patrickmn/go-cache"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
 
 	"gopkg.in/yaml.v2"
 )
 
 const aggSpan = 5
 
+// Kind is the registry kind of the domains
+var Kind = registry.Kind{Name: "domain", Expiry: time.Minute}
+
+// memTrendInterval and memTrendWindow define how the memory consumption of domains is tracked over time
+const memTrendInterval = 10 * time.Second
+const memTrendWindow = 5 * time.Minute
+
+// smapsInterval is the interval at which the hugepages used by a domain are read from the smaps_rollup of its qemu
+// process. Reading it walks all the mappings of the guest memory, so in between the last read is kept.
+const smapsInterval = 10 * time.Second
+
+// smapsChange is the change of the memory of a domain in MB since the last read of smaps_rollup after which it is
+// read again before smapsInterval elapsed
+const smapsChange float64 = 64
+
 type operation string
 type sample struct {
 	Avg   float64 `json:"avg"`
@@ -45,49 +61,104 @@ func getStatistics(key string) (map[string]state, error) {
 	return stats, nil
 }
 
-func getCachedDomain(key string, c *cache.Cache) (*Domain, error) {
-	cachedDomain := &Domain{
-		releaseFactor: 1,
-	}
+// statistics returns the statistics collected by corectl for a key, it is only replaced by tests
+var statistics = getStatistics
+
+// memTrends holds the memory trend of each domain, it is only accessed by UpdateCache
+var memTrends = make(map[string]*utils.Trend)
+
+// smapsRead is the hugepages usage of a domain as of the last read of smaps_rollup
+type smapsRead struct {
+	hugePages uint64
+	memory    float64 // memory of the domain at the time of the read
+	at        time.Time
+}
+
+// smapsReads holds the last read of smaps_rollup of each domain, it is only accessed by UpdateCache
+var smapsReads = make(map[string]*smapsRead)
+
+// snapshots holds the snapshots of the domains built during an update
+type snapshots map[string]*Domain
+
+// getCachedDomain returns the snapshot being built for the domain of a statistics key, it starts as a copy of
+// the snapshot in the registry so that the values that fail to be updated are kept.
+func getCachedDomain(key string, c *registry.Registry, domains snapshots) (*Domain, error) {
 	splits := strings.Split(key, "/")
 	if len(splits) != 2 {
 		message := fmt.Sprintf("Statistics key %v doesn't match the expected format", key)
 		log.Error(message)
-		return cachedDomain, fmt.Errorf(message)
+		return nil, fmt.Errorf(message)
 	}
+	name := splits[1]
 
-	if d, ok := c.Get(splits[1]); ok {
-		cachedDomain = d.(*Domain)
+	if d, ok := domains[name]; ok {
+		return d, nil
+	}
+	cachedDomain := &Domain{}
+	if d, ok := c.Get(Kind, name); ok {
+		*cachedDomain = *d.(*Domain)
 	}
-	cachedDomain.name = splits[1]
+	cachedDomain.name = name
+	domains[name] = cachedDomain
 	return cachedDomain, nil
 }
 
-func addDomainMemory(c *cache.Cache) error {
-	stats, err := getStatistics("kvm.memory.max")
+func addDomainMemory(c *registry.Registry, domains snapshots) error {
+	stats, err := statistics("kvm.memory.max")
 	if err != nil {
 		log.Errorf("Error getting domains memory statistics: %v", err)
 		return err
 	}
 	for key, stat := range stats {
-		cachedDomain, err := getCachedDomain(key, c)
+		cachedDomain, err := getCachedDomain(key, c, domains)
 		if err != nil {
 			continue
 		}
+		trend, ok := memTrends[cachedDomain.name]
+		if !ok {
+			trend = utils.NewTrend(memTrendInterval, memTrendWindow)
+			memTrends[cachedDomain.name] = trend
+		}
+		now := time.Now()
+		trend.Add(now, stat.LastValue)
+
 		cachedDomain.memUsage = stat.LastValue
-		c.Set(cachedDomain.name, cachedDomain, time.Minute)
+		cachedDomain.memGrowth = trend.Growth()
+		if hugePages, err := readHugePages(cachedDomain, now); err == nil {
+			cachedDomain.hugePages = hugePages
+		}
 	}
 	return nil
 }
 
-func addDomainCPU(c *cache.Cache) error {
-	stats, err := getStatistics("kvm.cpu.time")
+// readHugePages returns the hugepages in MB used by a domain. smaps_rollup is only read again once smapsInterval
+// elapsed or the memory of the domain changed by smapsChange.
+func readHugePages(d *Domain, now time.Time) (uint64, error) {
+	last, ok := smapsReads[d.name]
+	if ok && now.Sub(last.at) < smapsInterval && math.Abs(d.memUsage-last.memory) < smapsChange {
+		return last.hugePages, nil
+	}
+
+	pid, err := d.pid()
+	if err != nil {
+		return 0, err
+	}
+	usage, err := utils.ReadSmapsRollup(pid)
+	if err != nil {
+		return 0, err
+	}
+	smapsReads[d.name] = &smapsRead{usage.HugePages, d.memUsage, now}
+	return usage.HugePages, nil
+}
+
+func addDomainCPU(c *registry.Registry, domains snapshots) error {
+	stats, err := statistics("kvm.cpu.time")
 	if err != nil {
 		log.Errorf("Error getting domains cpu statistics: %v", err)
 		return err
 	}
 	for key, stat := range stats {
-		cachedDomain, err := getCachedDomain(key, c)
+		cachedDomain, err := getCachedDomain(key, c, domains)
 		if err != nil {
 			log.Error(err)
 			continue
@@ -96,66 +167,27 @@ func addDomainCPU(c *cache.Cache) error {
 		if _, ok := stat.Current["300"]; ok {
 			cachedDomain.cpuTime = stat.Current["300"].Total / float64(time.Now().Unix()-stat.Current["300"].Start)
 		}
-		c.Set(cachedDomain.name, cachedDomain, time.Minute)
 	}
 	return nil
 }
 
-func addCpuAggregation(c *cache.Cache) error {
-	conn, err := libvirt.NewConnect(connectionURI)
+// UpdateCache publishes a new snapshot of each domain
+func UpdateCache(c *registry.Registry) {
+	domains := make(snapshots)
+	addDomainCPU(c, domains)
+	addDomainMemory(c, domains)
 
-	if err != nil {
-		log.Errorf("Error connecting to qemu: %v", err)
-		return err
+	for name, d := range domains {
+		c.Set(Kind, name, d)
 	}
-	defer conn.Close()
-
-	items := c.Items()
-	for _, item := range items {
-		d, ok := item.Object.(*Domain)
-		// This is not a domain or it is a domain but it is not in release state
-		// no need to calculate cpu agg.
-		if !ok || !d.release {
-			continue
-		}
-
-		// Aggregation has already been measured
-		if d.release  && d.cpuAgg.end.timestamp != 0 {
-			continue
-		}
-
-		dom, err := conn.LookupDomainByName(d.name)
-		if err != nil {
-			log.Errorf("Error looking up domain by name: %v", err)
-			continue
-		}
-		defer dom.Free()
-
-		info, err := dom.GetInfo()
-		if err != nil {
-			log.Errorf("Error getting domain info: %v", err)
-			continue
-		}
-
-		timestamp := time.Now().Unix()
-		if (d.cpuAgg == cpuAggregation{}) {
-			d.cpuAgg.start.timestamp = timestamp
-			d.cpuAgg.start.totalTime = float64(info.CpuTime) / 1000000000.
-			c.Set(d.name, d, time.Minute)
-			continue
+	for name := range memTrends {
+		if _, ok := c.Get(Kind, name); !ok {
+			delete(memTrends, name)
 		}
-
-		if d.cpuAgg.end.timestamp == 0 && (timestamp-d.cpuAgg.start.timestamp) >= aggSpan {
-			d.cpuAgg.end.timestamp = timestamp
-			d.cpuAgg.end.totalTime = float64(info.CpuTime) /1000000000.
-			c.Set(d.name, d, time.Minute)
+	}
+	for name := range smapsReads {
+		if _, ok := c.Get(Kind, name); !ok {
+			delete(smapsReads, name)
 		}
 	}
-	return nil
-}
-
-func UpdateCache(c *cache.Cache) {
-	addDomainCPU(c)
-	addDomainMemory(c)
-	addCpuAggregation(c)
 }
@@ -0,0 +1,110 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zero-os/0-ork/fairusage"
+	"github.com/zero-os/0-ork/orktest"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+func TestReadHugePagesPeriodically(t *testing.T) {
+	f := orktest.NewHost(t, func() {
+		smapsReads = make(map[string]*smapsRead)
+	})
+	f.Set(fmt.Sprintf(pidPath, "vm"), "100\n")
+	setHugePages := func(mb uint64) {
+		f.Set("/proc/100/smaps_rollup", fmt.Sprintf("Rss: 1024 kB\nPss: 1024 kB\nPrivate_Hugetlb: %v kB\n", mb*1024))
+	}
+	d := &Domain{name: "vm", memUsage: 4096}
+	now := time.Now()
+
+	setHugePages(2048)
+	if hugePages, err := readHugePages(d, now); err != nil || hugePages != 2048 {
+		t.Fatalf("expected the hugepages of smaps_rollup, got %v: %v", hugePages, err)
+	}
+
+	// smaps_rollup is not read again before smapsInterval unless the memory of the domain changes
+	setHugePages(4096)
+	if hugePages, _ := readHugePages(d, now.Add(time.Second)); hugePages != 2048 {
+		t.Errorf("expected the hugepages to be kept within smapsInterval, got %v", hugePages)
+	}
+	d.memUsage = 8192
+	if hugePages, _ := readHugePages(d, now.Add(time.Second)); hugePages != 4096 {
+		t.Errorf("expected smaps_rollup to be read again once the memory changed, got %v", hugePages)
+	}
+	setHugePages(1024)
+	if hugePages, _ := readHugePages(d, now.Add(smapsInterval+time.Second)); hugePages != 1024 {
+		t.Errorf("expected smaps_rollup to be read again after smapsInterval, got %v", hugePages)
+	}
+}
+
+// TestConcurrentCollectionAndQuarantine runs the collection of the domains while the fair usage monitor
+// quarantines them, it is meant to be run with -race
+func TestConcurrentCollectionAndQuarantine(t *testing.T) {
+	s := newSimulation(t)
+	s.hypervisor.Add("vm", 2)
+	t.Cleanup(func() {
+		statistics = getStatistics
+		memTrends = make(map[string]*utils.Trend)
+		smapsReads = make(map[string]*smapsRead)
+	})
+	// the domain consumes 90% of a cpu
+	statistics = func(key string) (map[string]state, error) {
+		switch key {
+		case "kvm.memory.max":
+			return map[string]state{"kvm.memory.max/vm": {LastValue: 2048}}, nil
+		case "kvm.cpu.time":
+			return map[string]state{"kvm.cpu.time/vm": {Current: samples{"300": {Total: 270, Start: time.Now().Unix() - 300}}}}, nil
+		}
+		return nil, fmt.Errorf("unknown statistics %v", key)
+	}
+
+	r := registry.New()
+	UpdateCache(r)
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	// collector
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			UpdateCache(r)
+		}
+	}()
+
+	// monitors reading the snapshots and quarantining the domain within warnTime and quarantineTime
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 40; i++ {
+			s.clock.Advance(30 * time.Second)
+			if err := fairusage.Monitor(context.Background(), r); err != nil {
+				t.Error(err)
+			}
+			r.Range(func(value interface{}) bool {
+				d := value.(*Domain)
+				_ = d.Memory() + d.HugePages()
+				_ = d.MemoryGrowth().Rate + d.CPUAverage()
+				return true
+			})
+		}
+	}()
+	wg.Wait()
+
+	if !s.pinned(t, "vm") {
+		t.Error("expected the domain over its fair usage to be quarantined while it was collected")
+	}
+}
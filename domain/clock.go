@@ -0,0 +1,27 @@
+package domain
+
+import (
+	"time"
+)
+
+// Clock tells the time to the fair usage state machine
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock of the host
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// clock is the Clock used by ORK, it is only replaced by tests before the monitors are started
+var clock Clock = systemClock{}
+
+// UseClock makes ORK use c and returns the Clock used before, it is meant for tests
+func UseClock(c Clock) Clock {
+	previous := clock
+	clock = c
+	return previous
+}
@@ -3,16 +3,23 @@ package domain
 import (
 	"fmt"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/libvirt/libvirt-go"
 	"github.com/op/go-logging"
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/registry"
 	"github.com/zero-os/0-ork/utils"
 )
 
 const connectionURI string = "qemu:///system"
+const pidPath string = "/var/run/libvirt/qemu/%v.pid"
 const overSubscription = 4
 
+// quarantineExpiry is the time after which the fair usage state of a domain that is gone is forgotten
+const quarantineExpiry = time.Minute
+
 var log = logging.MustGetLogger("ORK")
 
 type cpu struct {
@@ -26,7 +33,15 @@ var quarantinedDomains map[string]interface{}
 var totalCpus = runtime.NumCPU()
 
 func init() {
-	quarantinedDomains = make(map[string]interface{})
+	reserveCPUs(totalCpus)
+	InitializeCPUs()
+}
+
+// reserveCPUs reserves the first cpus of a host of total cpus for the host itself, the other cpus make the
+// quarantine section.
+func reserveCPUs(total int) {
+	totalCpus = total
+	physCpus = nil
 	cpus = make(map[int]*cpu)
 
 	// Determine the number of cpu cores to be reserved for the host
@@ -42,30 +57,25 @@ func init() {
 		physCpus = append(physCpus, i)
 		cpus[i] = &cpu{vms: make(map[string]int)}
 	}
-	InitializeCPUs()
 }
 
+// InitializeCPUs rebuilds the quarantine section from the vcpus pinned to a single cpu, so that the domains
+// quarantined before ORK restarted stay accounted.
 func InitializeCPUs() {
-	conn, err := libvirt.NewConnect("qemu:///system")
-	if err != nil {
-		log.Errorf("Failed to initialize cpus: %v", err)
-		return
+	quarantinedDomains = make(map[string]interface{})
+	for _, cpu := range cpus {
+		cpu.count = 0
+		cpu.vms = make(map[string]int)
 	}
-	defer conn.Close()
 
-	domains, err := conn.ListAllDomains(libvirt.CONNECT_LIST_DOMAINS_RUNNING)
+	domains, err := hypervisor.Domains()
 	if err != nil {
 		log.Errorf("Failed to initialize cpus: %v", err)
 		return
 	}
 
-	for _, domain := range domains {
-		name, err := domain.GetName()
-		if err != nil {
-			log.Errorf("Error getting domain's name: %v", err)
-			continue
-		}
-		vcpus, err := domain.GetVcpuPinInfo(libvirt.DOMAIN_AFFECT_LIVE)
+	for _, name := range domains {
+		vcpus, err := hypervisor.VcpuPins(name)
 		if err != nil {
 			log.Errorf("Error getting vcpu pin info for domain %v: %v", name, err)
 			continue
@@ -121,11 +131,17 @@ type cpuAggregation struct {
 	end   cpuUnit
 }
 
+// Domain is a snapshot of a domain published by UpdateCache, it is never modified once it is in the registry
 type Domain struct {
-	domain          libvirt.Domain
-	memUsage        float64
-	cpuTime         float64
-	name            string
+	memUsage  float64
+	memGrowth utils.Growth
+	hugePages uint64
+	cpuTime   float64
+	name      string
+}
+
+// quarantine holds the fair usage state of a domain
+type quarantine struct {
 	threshold       bool
 	thresholdStart  int64
 	warn            bool
@@ -137,33 +153,88 @@ type Domain struct {
 	releaseFactor   int64
 	postRelease     bool
 	cpuAgg          cpuAggregation
+	seen            time.Time
+}
+
+// quarantines holds the fair usage state of the domains. Like quarantinedDomains and cpus it is owned by the
+// fairusage monitor which is the only caller of Limit and UnLimit.
+var quarantines = make(map[string]*quarantine)
+var lastPrune time.Time
+
+// getQuarantine returns the fair usage state of the domain and forgets the state of the domains that
+// were not seen for quarantineExpiry.
+func (d *Domain) getQuarantine() *quarantine {
+	now := clock.Now()
+	if now.Sub(lastPrune) >= quarantineExpiry {
+		for name, q := range quarantines {
+			if now.Sub(q.seen) >= quarantineExpiry {
+				delete(quarantines, name)
+			}
+		}
+		lastPrune = now
+	}
+
+	q, ok := quarantines[d.name]
+	if !ok {
+		q = &quarantine{releaseFactor: 1}
+		quarantines[d.name] = q
+	}
+	q.seen = now
+	return q
+}
+
+// measureRelease measures the cpu consumption of the domain during the first aggSpan seconds of its release
+func (d *Domain) measureRelease(q *quarantine) {
+	if !q.release || q.cpuAgg.end.timestamp != 0 {
+		return
+	}
+
+	info, err := hypervisor.Info(d.name)
+	if err != nil {
+		log.Errorf("Error getting domain info: %v", err)
+		return
+	}
+
+	timestamp := clock.Now().Unix()
+	if (q.cpuAgg == cpuAggregation{}) {
+		q.cpuAgg.start.timestamp = timestamp
+		q.cpuAgg.start.totalTime = float64(info.CPUTime) / 1000000000.
+		return
+	}
+
+	if (timestamp - q.cpuAgg.start.timestamp) >= aggSpan {
+		q.cpuAgg.end.timestamp = timestamp
+		q.cpuAgg.end.totalTime = float64(info.CPUTime) / 1000000000.
+	}
 }
 
 func (d *Domain) Limit(warn int64, quarantine int64) {
-	now := time.Now().Unix()
+	now := clock.Now().Unix()
+	q := d.getQuarantine()
+	d.measureRelease(q)
 
-	if !d.threshold {
+	if !q.threshold {
 		log.Debugf("Domain %v is in threshold state", d.name)
-		d.threshold = true
-		d.thresholdStart = now
+		q.threshold = true
+		q.thresholdStart = now
 		return
 	}
 
-	if !d.warn && (now-d.thresholdStart) >= warn {
+	if !q.warn && (now-q.thresholdStart) >= warn {
 		log.Debugf("Domain %v is in warning state", d.name)
 		utils.LogEvent(utils.Quarantine, d.name, utils.Warning)
-		d.warn = true
-		d.warnStart = now
+		q.warn = true
+		q.warnStart = now
 		return
 	}
 
-	if d.warn && !d.quarantine && (now-d.warnStart) >= quarantine {
+	if q.warn && !q.quarantine && (now-q.warnStart) >= quarantine {
 		log.Debugf("Domain %v is in quarantine state", d.name)
-		d.quarantine = true
-		d.quarantineStart = time.Now().Unix()
+		q.quarantine = true
+		q.quarantineStart = now
 		if _, ok := quarantinedDomains[d.name]; !ok {
 			if err := d.startQuarantine(); err != nil {
-				d.quarantine = false
+				q.quarantine = false
 			} else {
 				utils.LogEvent(utils.Quarantine, d.name, utils.Success)
 			}
@@ -172,78 +243,66 @@ func (d *Domain) Limit(warn int64, quarantine int64) {
 }
 
 func (d *Domain) UnLimit(releaseTime int64, threshold float64) {
-	now := time.Now().Unix()
+	now := clock.Now().Unix()
+	q := d.getQuarantine()
+	d.measureRelease(q)
 
-	if !d.quarantine {
+	if !q.quarantine {
 		// This domain was quarantined but the flags were reset due to ork restart
 		// set quarantine to true and let it take the normal cycle
 		if _, ok := quarantinedDomains[d.name]; ok {
 			log.Debugf("setting quarantine flag")
-			d.quarantine = true
-			d.quarantineStart = now
+			q.quarantine = true
+			q.quarantineStart = now
 			return
 		}
-		d.threshold = false
-		d.warn = false
-		d.release = false
+		q.threshold = false
+		q.warn = false
+		q.release = false
 		return
 	}
 
 	// Check if the domain is quarantined and is ready to be released
-	if d.quarantine && !d.release && (now-d.quarantineStart) >= releaseTime*d.releaseFactor {
+	if q.quarantine && !q.release && (now-q.quarantineStart) >= releaseTime*q.releaseFactor {
 		log.Debugf("Testing domain %v release", d.name)
-		d.release = true
-		d.releaseStart = now
+		q.release = true
+		q.releaseStart = now
 		if err := d.stopQuarantine(); err != nil {
 			log.Debugf("Failed to release domain %v", d.name)
-			d.release = false
+			q.release = false
 		}
 		return
 	}
 
 	// Check if the domain behaved well during the release window and release it for good if it did
 	// or quarantine it again if it didn't
-	if !d.postRelease && d.release && d.cpuAgg.end.timestamp != 0 {
-		d.postRelease = true
-		agg := float64(d.cpuAgg.end.totalTime-d.cpuAgg.start.totalTime) / float64(d.cpuAgg.end.timestamp-d.cpuAgg.start.timestamp)
+	if !q.postRelease && q.release && q.cpuAgg.end.timestamp != 0 {
+		q.postRelease = true
+		agg := float64(q.cpuAgg.end.totalTime-q.cpuAgg.start.totalTime) / float64(q.cpuAgg.end.timestamp-q.cpuAgg.start.timestamp)
 		if agg >= threshold {
-			d.releaseFactor = d.releaseFactor * 2
+			q.releaseFactor = q.releaseFactor * 2
 			log.Debugf("Domain %v is still misbehaving after release and will be put in quarantine again.", d.name)
 			if err := d.startQuarantine(); err != nil {
-				d.quarantine = false
+				q.quarantine = false
 			} else {
-				d.quarantineStart = now
+				q.quarantineStart = now
 				utils.LogEvent(utils.Quarantine, d.name, utils.Success)
 			}
 		} else {
 			log.Debugf("Domain %v is released for good.", d.name)
 			utils.LogEvent(utils.UnQuarantine, d.name, utils.Success)
-			d.quarantine = false
-			d.threshold = false
-			d.warn = false
+			q.quarantine = false
+			q.threshold = false
+			q.warn = false
 		}
-		d.release = false
-		d.postRelease = false
-		d.cpuAgg = cpuAggregation{}
+		q.release = false
+		q.postRelease = false
+		q.cpuAgg = cpuAggregation{}
 	}
 }
 
 func (d *Domain) stopQuarantine() error {
-	conn, err := libvirt.NewConnect(connectionURI)
-	if err != nil {
-		log.Errorf("Error removing %v from quarantine: %v", d.name, err)
-		return err
-	}
-	defer conn.Close()
-
-	dom, err := conn.LookupDomainByName(d.name)
-	if err != nil {
-		log.Errorf("Error removing %v from quarantine: %v", d.name, err)
-		return err
-	}
-	defer dom.Free()
-
-	vcpus, err := dom.GetVcpusFlags(libvirt.DOMAIN_VCPU_LIVE)
+	info, err := hypervisor.Info(d.name)
 	if err != nil {
 		log.Errorf("Error removing %v from quarantine: %v", d.name, err)
 		return err
@@ -254,11 +313,12 @@ func (d *Domain) stopQuarantine() error {
 		cpuMap[i] = true
 	}
 
-	for i := 0; i < int(vcpus); i++ {
-		err := dom.PinVcpu(uint(i), cpuMap)
-		if err != nil {
-			log.Errorf("Error pining vcpu %v for domain %v: %v", i, d.name, err)
-		}
+	pins := make(map[uint][]bool, info.Vcpus)
+	for i := 0; i < int(info.Vcpus); i++ {
+		pins[uint(i)] = cpuMap
+	}
+	if err := hypervisor.PinVcpus(d.name, pins); err != nil {
+		log.Errorf("Error unpinning vcpus of domain %v: %v", d.name, err)
 	}
 	for _, cpu := range cpus {
 		cpu.decrement(d.name)
@@ -268,25 +328,12 @@ func (d *Domain) stopQuarantine() error {
 }
 
 func (d *Domain) startQuarantine() error {
-	conn, err := libvirt.NewConnect(connectionURI)
-	if err != nil {
-		log.Errorf("Error adding %v to quarantine: %v", d.name, err)
-		return err
-	}
-	defer conn.Close()
-
-	dom, err := conn.LookupDomainByName(d.name)
-	if err != nil {
-		log.Errorf("Error adding %v to quarantine: %v", d.name, err)
-		return err
-	}
-	defer dom.Free()
-
-	vcpus, err := dom.GetVcpusFlags(libvirt.DOMAIN_VCPU_LIVE)
+	info, err := hypervisor.Info(d.name)
 	if err != nil {
 		log.Errorf("Error adding %v to quarantine: %v", d.name, err)
 		return err
 	}
+	vcpus := info.Vcpus
 
 	vcpu := int32(0)
 	cpuPins := make(map[int][]int32, vcpus)
@@ -312,18 +359,20 @@ Outer:
 		return fmt.Errorf(message)
 	}
 
+	pins := make(map[uint][]bool, vcpus)
 	for cpu, vcpus := range cpuPins {
 		cpuMap := make([]bool, totalCpus, totalCpus)
 		cpuMap[cpu] = true
 		for _, vcpu := range vcpus {
-			err := dom.PinVcpu(uint(vcpu), cpuMap)
-			if err != nil {
-				log.Errorf("Error pining vcpu %v for domain %v: %v", vcpu, d.name, err)
-				d.stopQuarantine()
-				return err
-			}
+			pins[uint(vcpu)] = cpuMap
 		}
-
+	}
+	if err := hypervisor.PinVcpus(d.name, pins); err != nil {
+		log.Errorf("Error pining vcpus for domain %v: %v", d.name, err)
+		d.stopQuarantine()
+		return err
+	}
+	for cpu, vcpus := range cpuPins {
 		cpus[cpu].increment(d.name, len(vcpus))
 	}
 	quarantinedDomains[d.name] = struct{}{}
@@ -334,8 +383,45 @@ func (d *Domain) CPUAverage() float64 {
 	return d.cpuTime
 }
 
+// Memory returns the normal memory of the domain in MB, the memory backed by hugepages is not accounted
+// since destroying the domain only gives it back to the hugepages pool.
 func (d *Domain) Memory() uint64 {
-	return uint64(d.memUsage)
+	if memory := uint64(d.memUsage); memory > d.hugePages {
+		return memory - d.hugePages
+	}
+	return 0
+}
+
+// HugePages returns the memory of the domain backed by hugepages in MB
+func (d *Domain) HugePages() uint64 {
+	return d.hugePages
+}
+
+// MemoryGrowth returns how the memory consumption of the domain evolved over the last minutes
+func (d *Domain) MemoryGrowth() utils.Growth {
+	return d.memGrowth
+}
+
+// pid returns the pid of the qemu process running the domain
+func (d *Domain) pid() (int32, error) {
+	contents, err := host.ReadFile(fmt.Sprintf(pidPath, d.name))
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(contents)), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return int32(pid), nil
+}
+
+// NodeMemory returns the memory in MB the domain has on each NUMA node
+func (d *Domain) NodeMemory() (map[int]uint64, error) {
+	pid, err := d.pid()
+	if err != nil {
+		return nil, err
+	}
+	return utils.NodeMemory(pid)
 }
 
 func (d *Domain) Priority() int {
@@ -346,24 +432,18 @@ func (d *Domain) Name() string {
 	return d.name
 }
 
-func (d *Domain) Kill() error {
-	conn, err := libvirt.NewConnect(connectionURI)
+func (d *Domain) Kind() registry.Kind {
+	return Kind
+}
 
-	if err != nil {
-		log.Error("Error connecting to qemu")
-		return err
-	}
-	defer conn.Close()
-	dom, err := conn.LookupDomainByName(d.name)
-	if err != nil {
-		log.Error("Error looking up domain by name")
-		return err
-	}
-	defer dom.Free()
+func (d *Domain) ID() string {
+	return d.name
+}
 
+func (d *Domain) Kill() error {
 	utils.LogToKernel("ORK: attempting to destroy machine %v\n", d.name)
 
-	if err = dom.DestroyFlags(1); err != nil {
+	if err := hypervisor.Destroy(d.name); err != nil {
 		utils.LogToKernel("ORK: error destroying machine %v\n", d.name)
 		log.Errorf("Error destroying machine %v: %v", d.name, err)
 		return err
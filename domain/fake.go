@@ -0,0 +1,160 @@
+package domain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FakeClock is a Clock for tests that only moves when it is advanced
+type FakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// NewFakeClock returns a FakeClock set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeDomain is a domain of a FakeHypervisor
+type fakeDomain struct {
+	pins    [][]bool
+	cpuTime time.Duration
+}
+
+// FakeHypervisor is an in-memory Hypervisor for tests
+type FakeHypervisor struct {
+	lock    sync.Mutex
+	cpus    int
+	domains map[string]*fakeDomain
+}
+
+// NewFakeHypervisor returns a FakeHypervisor of a host with cpus physical cpus and no domains
+func NewFakeHypervisor(cpus int) *FakeHypervisor {
+	return &FakeHypervisor{
+		cpus:    cpus,
+		domains: make(map[string]*fakeDomain),
+	}
+}
+
+// Add starts a domain whose vcpus may run on all the physical cpus
+func (h *FakeHypervisor) Add(name string, vcpus int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	d := &fakeDomain{pins: make([][]bool, vcpus)}
+	for i := range d.pins {
+		d.pins[i] = make([]bool, h.cpus)
+		for j := range d.pins[i] {
+			d.pins[i][j] = true
+		}
+	}
+	h.domains[name] = d
+}
+
+// Run accounts cpuTime to the domain name
+func (h *FakeHypervisor) Run(name string, cpuTime time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if d, ok := h.domains[name]; ok {
+		d.cpuTime += cpuTime
+	}
+}
+
+// Exists returns true if the domain name is running
+func (h *FakeHypervisor) Exists(name string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	_, ok := h.domains[name]
+	return ok
+}
+
+func (h *FakeHypervisor) domain(name string) (*fakeDomain, error) {
+	d, ok := h.domains[name]
+	if !ok {
+		return nil, fmt.Errorf("Domain %v not found", name)
+	}
+	return d, nil
+}
+
+func (h *FakeHypervisor) Domains() ([]string, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	names := make([]string, 0, len(h.domains))
+	for name := range h.domains {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (h *FakeHypervisor) Info(name string) (DomainInfo, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	d, err := h.domain(name)
+	if err != nil {
+		return DomainInfo{}, err
+	}
+	return DomainInfo{Vcpus: int32(len(d.pins)), CPUTime: uint64(d.cpuTime)}, nil
+}
+
+func (h *FakeHypervisor) VcpuPins(name string) ([][]bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	d, err := h.domain(name)
+	if err != nil {
+		return nil, err
+	}
+	pins := make([][]bool, len(d.pins))
+	for i := range d.pins {
+		pins[i] = append([]bool(nil), d.pins[i]...)
+	}
+	return pins, nil
+}
+
+func (h *FakeHypervisor) PinVcpus(name string, pins map[uint][]bool) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	d, err := h.domain(name)
+	if err != nil {
+		return err
+	}
+	var first error
+	for vcpu, cpuMap := range pins {
+		if int(vcpu) >= len(d.pins) {
+			err = fmt.Errorf("Domain %v has no vcpu %v", name, vcpu)
+		} else if len(cpuMap) > h.cpus {
+			err = fmt.Errorf("Host has no cpu %v", len(cpuMap)-1)
+		} else {
+			d.pins[vcpu] = make([]bool, h.cpus)
+			copy(d.pins[vcpu], cpuMap)
+			continue
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (h *FakeHypervisor) Destroy(name string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, err := h.domain(name); err != nil {
+		return err
+	}
+	delete(h.domains, name)
+	return nil
+}
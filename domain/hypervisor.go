@@ -0,0 +1,128 @@
+package domain
+
+import (
+	"github.com/libvirt/libvirt-go"
+)
+
+// DomainInfo holds the state of a running domain
+type DomainInfo struct {
+	Vcpus   int32  // number of live vcpus
+	CPUTime uint64 // cpu time consumed by the domain since it started in nanoseconds
+}
+
+// Hypervisor is the part of the hypervisor used by ORK to pin and destroy domains
+type Hypervisor interface {
+	// Domains returns the names of the running domains
+	Domains() ([]string, error)
+	// Info returns the state of a domain
+	Info(name string) (DomainInfo, error)
+	// VcpuPins returns for each vcpu of a domain the physical cpus it may run on
+	VcpuPins(name string) ([][]bool, error)
+	// PinVcpus restricts each vcpu of a domain in pins to the physical cpus set in its cpu map. All the vcpus
+	// are pinned even if one of them fails, the first error is returned.
+	PinVcpus(name string, pins map[uint][]bool) error
+	// Destroy destroys a domain
+	Destroy(name string) error
+}
+
+// libvirtHypervisor is the Hypervisor of the host, it connects to libvirt for each operation so that
+// a restart of libvirtd doesn't break ORK.
+type libvirtHypervisor struct{}
+
+// hypervisor is the Hypervisor used by ORK, it is only replaced by tests before the monitors are started
+var hypervisor Hypervisor = libvirtHypervisor{}
+
+// UseHypervisor makes ORK use h and returns the Hypervisor used before, it is meant for tests
+func UseHypervisor(h Hypervisor) Hypervisor {
+	previous := hypervisor
+	hypervisor = h
+	return previous
+}
+
+// lookup calls f with the domain name
+func (libvirtHypervisor) lookup(name string, f func(dom *libvirt.Domain) error) error {
+	conn, err := libvirt.NewConnect(connectionURI)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	dom, err := conn.LookupDomainByName(name)
+	if err != nil {
+		return err
+	}
+	defer dom.Free()
+	return f(dom)
+}
+
+func (libvirtHypervisor) Domains() ([]string, error) {
+	conn, err := libvirt.NewConnect(connectionURI)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	domains, err := conn.ListAllDomains(libvirt.CONNECT_LIST_DOMAINS_RUNNING)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		name, err := domain.GetName()
+		domain.Free()
+		if err != nil {
+			log.Errorf("Error getting domain's name: %v", err)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (h libvirtHypervisor) Info(name string) (DomainInfo, error) {
+	var info DomainInfo
+	err := h.lookup(name, func(dom *libvirt.Domain) error {
+		vcpus, err := dom.GetVcpusFlags(libvirt.DOMAIN_VCPU_LIVE)
+		if err != nil {
+			return err
+		}
+		domInfo, err := dom.GetInfo()
+		if err != nil {
+			return err
+		}
+		info = DomainInfo{Vcpus: vcpus, CPUTime: domInfo.CpuTime}
+		return nil
+	})
+	return info, err
+}
+
+func (h libvirtHypervisor) VcpuPins(name string) ([][]bool, error) {
+	var pins [][]bool
+	err := h.lookup(name, func(dom *libvirt.Domain) error {
+		var err error
+		pins, err = dom.GetVcpuPinInfo(libvirt.DOMAIN_AFFECT_LIVE)
+		return err
+	})
+	return pins, err
+}
+
+func (h libvirtHypervisor) PinVcpus(name string, pins map[uint][]bool) error {
+	return h.lookup(name, func(dom *libvirt.Domain) error {
+		var first error
+		for vcpu, cpuMap := range pins {
+			if err := dom.PinVcpu(vcpu, cpuMap); err != nil {
+				log.Errorf("Error pining vcpu %v for domain %v: %v", vcpu, name, err)
+				if first == nil {
+					first = err
+				}
+			}
+		}
+		return first
+	})
+}
+
+func (h libvirtHypervisor) Destroy(name string) error {
+	return h.lookup(name, func(dom *libvirt.Domain) error {
+		return dom.DestroyFlags(libvirt.DOMAIN_DESTROY_GRACEFUL)
+	})
+}
@@ -0,0 +1,211 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zero-os/0-ork/orktest"
+)
+
+// the parameters of the fair usage policy as set by the fairusage monitor
+const (
+	threshold      = 0.8
+	warnTime       = 300
+	quarantineTime = 600
+	releaseTime    = 300
+)
+
+// simulation runs the fair usage state machine of domains on a fake host of 8 cpus, the first cpu is
+// reserved for the host and the 7 others make the quarantine section.
+type simulation struct {
+	clock      *FakeClock
+	hypervisor *FakeHypervisor
+}
+
+func newSimulation(t *testing.T) *simulation {
+	s := &simulation{
+		clock:      NewFakeClock(time.Unix(1500000000, 0)),
+		hypervisor: NewFakeHypervisor(8),
+	}
+	previousClock := UseClock(s.clock)
+	previousHypervisor := UseHypervisor(s.hypervisor)
+	total := totalCpus
+	orktest.NewHost(t, func() {
+		UseClock(previousClock)
+		UseHypervisor(previousHypervisor)
+		reserveCPUs(total)
+		quarantinedDomains = make(map[string]interface{})
+		quarantines = make(map[string]*quarantine)
+		lastPrune = time.Time{}
+	})
+	reserveCPUs(8)
+	InitializeCPUs()
+	return s
+}
+
+// run calls step every second while the domain consumes usage cpu seconds per second until done returns true
+// and returns the number of seconds it took, it gives up after an hour.
+func (s *simulation) run(d *Domain, usage float64, step func(d *Domain), done func() bool) int {
+	for seconds := 1; seconds <= 3600; seconds++ {
+		s.clock.Advance(time.Second)
+		s.hypervisor.Run(d.name, time.Duration(usage*float64(time.Second)))
+		step(d)
+		if done() {
+			return seconds
+		}
+	}
+	return -1
+}
+
+func limit(d *Domain)   { d.Limit(warnTime, quarantineTime) }
+func unLimit(d *Domain) { d.UnLimit(releaseTime, threshold) }
+
+// pinned returns true if all the vcpus of the domain are pinned to a single cpu of the quarantine section
+func (s *simulation) pinned(t *testing.T, name string) bool {
+	pins, err := s.hypervisor.VcpuPins(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cpuMap := range pins {
+		var count, pin int
+		for cpu, allowed := range cpuMap {
+			if allowed {
+				count++
+				pin = cpu
+			}
+		}
+		if count != 1 {
+			return false
+		}
+		if pin == 0 {
+			t.Errorf("vcpu of %v pinned to the cpu reserved for the host", name)
+		}
+	}
+	return true
+}
+
+func TestQuarantineLifecycle(t *testing.T) {
+	s := newSimulation(t)
+	s.hypervisor.Add("vm", 2)
+	d := &Domain{name: "vm"}
+	q := d.getQuarantine()
+
+	// 1) the domain is warned after consuming more than the threshold for warnTime
+	if seconds := s.run(d, 1, limit, func() bool { return q.warn }); seconds != warnTime+1 {
+		t.Errorf("expected the domain to be warned after %vs, got %vs", warnTime+1, seconds)
+	}
+	if s.pinned(t, "vm") {
+		t.Fatal("expected a warned domain not to be pinned")
+	}
+
+	// 2) the domain is quarantined quarantineTime after the warning
+	if seconds := s.run(d, 1, limit, func() bool { return q.quarantine }); seconds != quarantineTime {
+		t.Errorf("expected the domain to be quarantined after %vs, got %vs", quarantineTime, seconds)
+	}
+	if !s.pinned(t, "vm") {
+		t.Fatal("expected the vcpus of the quarantined domain to be pinned")
+	}
+
+	// 3) the domain is released after releaseTime and is quarantined again since it still misbehaves
+	if seconds := s.run(d, 0.5, unLimit, func() bool { return q.release }); seconds != releaseTime {
+		t.Errorf("expected the domain to be released after %vs, got %vs", releaseTime, seconds)
+	}
+	if s.pinned(t, "vm") {
+		t.Fatal("expected the vcpus of the released domain to be unpinned")
+	}
+	if seconds := s.run(d, 1, unLimit, func() bool { return !q.release }); seconds != aggSpan+1 {
+		t.Errorf("expected the release to be measured for %vs, got %vs", aggSpan+1, seconds)
+	}
+	if !q.quarantine || q.releaseFactor != 2 || !s.pinned(t, "vm") {
+		t.Fatalf("expected the misbehaving domain to be quarantined again with a doubled release time, "+
+			"quarantined %v release factor %v", q.quarantine, q.releaseFactor)
+	}
+
+	// 4) the next release is tried after twice releaseTime and the domain is released for good
+	if seconds := s.run(d, 0.5, unLimit, func() bool { return q.release }); seconds != 2*releaseTime {
+		t.Errorf("expected the domain to be released after %vs, got %vs", 2*releaseTime, seconds)
+	}
+	if seconds := s.run(d, 0.1, unLimit, func() bool { return !q.release }); seconds != aggSpan+1 {
+		t.Errorf("expected the release to be measured for %vs, got %vs", aggSpan+1, seconds)
+	}
+	if q.quarantine || q.warn || q.threshold || s.pinned(t, "vm") {
+		t.Errorf("expected the domain to be released for good, quarantined %v warned %v", q.quarantine, q.warn)
+	}
+	if _, ok := quarantinedDomains["vm"]; ok {
+		t.Error("expected the domain to be removed from the quarantined domains")
+	}
+	for cpu, c := range cpus {
+		if c.count != 0 {
+			t.Errorf("expected cpu %v of the quarantine section to be free, %v vcpus are pinned", cpu, c.count)
+		}
+	}
+}
+
+func TestQuarantineSurvivesRestart(t *testing.T) {
+	s := newSimulation(t)
+	s.hypervisor.Add("vm", 2)
+	cpuMap := []bool{false, false, false, true, false, false, false, false}
+	if err := s.hypervisor.PinVcpus("vm", map[uint][]bool{0: cpuMap, 1: cpuMap}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ORK restarts while the domain is quarantined
+	InitializeCPUs()
+	if _, ok := quarantinedDomains["vm"]; !ok || cpus[3].count != 2 {
+		t.Fatalf("expected the pinned domain to be accounted in the quarantine section, cpu 3 has %v vcpus", cpus[3].count)
+	}
+
+	d := &Domain{name: "vm"}
+	q := d.getQuarantine()
+	unLimit(d)
+	if !q.quarantine {
+		t.Fatal("expected the quarantine of the domain to be restored")
+	}
+	if seconds := s.run(d, 0.1, unLimit, func() bool { return q.release }); seconds != releaseTime {
+		t.Errorf("expected the domain to be released after %vs, got %vs", releaseTime, seconds)
+	}
+	if s.pinned(t, "vm") || cpus[3].count != 0 {
+		t.Error("expected the restored domain to be unpinned when released")
+	}
+}
+
+func TestQuarantineSectionFull(t *testing.T) {
+	s := newSimulation(t)
+	// the 7 cpus of the quarantine section hold 28 vcpus
+	s.hypervisor.Add("big", 30)
+	d := &Domain{name: "big"}
+	q := d.getQuarantine()
+
+	if seconds := s.run(d, 20, limit, func() bool { return q.quarantine }); seconds != -1 {
+		t.Fatalf("expected the domain not to be quarantined, got quarantined after %vs", seconds)
+	}
+	if !q.warn {
+		t.Error("expected the domain to stay warned")
+	}
+	if s.pinned(t, "big") {
+		t.Error("expected no vcpu to be pinned")
+	}
+	for cpu, c := range cpus {
+		if c.count != 0 {
+			t.Errorf("expected cpu %v to be free after the failed quarantine, %v vcpus are accounted", cpu, c.count)
+		}
+	}
+}
+
+func TestQuarantineExpiry(t *testing.T) {
+	s := newSimulation(t)
+	s.hypervisor.Add("vm", 1)
+	s.hypervisor.Add("gone", 1)
+	vm := &Domain{name: "vm"}
+	gone := &Domain{name: "gone"}
+
+	limit(vm)
+	limit(gone)
+	s.hypervisor.Destroy("gone")
+	if seconds := s.run(vm, 1, limit, func() bool { _, ok := quarantines["gone"]; return !ok }); seconds != int(quarantineExpiry/time.Second) {
+		t.Errorf("expected the state of the gone domain to be forgotten after %v, got %vs", quarantineExpiry, seconds)
+	}
+	if _, ok := quarantines["vm"]; !ok {
+		t.Error("expected the state of the running domain to be kept")
+	}
+}
@@ -1,6 +1,6 @@
 package fairusage
 
-import "github.com/patrickmn/go-cache"
+import "github.com/zero-os/0-ork/registry"
 
 type FairUsage interface {
 	CPUAverage() float64
@@ -9,17 +9,17 @@ type FairUsage interface {
 	Name() string
 }
 
-func GetFairUsageActivities(c *cache.Cache) []FairUsage {
-	items := c.Items()
-	activities := make([]FairUsage, 0, c.ItemCount())
+func GetFairUsageActivities(c *registry.Registry) []FairUsage {
+	activities := make([]FairUsage, 0, c.Len())
 
-	for _, item := range items {
-		activity, ok := item.Object.(FairUsage)
+	c.Range(func(value interface{}) bool {
+		activity, ok := value.(FairUsage)
 		if !ok {
-			continue
+			return true
 		}
 		activities = append(activities, activity)
-	}
+		return true
+	})
 
 	return activities
 }
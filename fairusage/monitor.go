@@ -1,8 +1,12 @@
 package fairusage
 
 import (
+	"context"
+	"time"
+
 	"github.com/op/go-logging"
-	"github.com/patrickmn/go-cache"
+	"github.com/zero-os/0-ork/monitor"
+	"github.com/zero-os/0-ork/registry"
 )
 
 var log = logging.MustGetLogger("ORK")
@@ -12,13 +16,20 @@ const quarantineTime int64 = 600
 const warnTime int64 = 300
 const releaseTime int64 = 300
 
+func init() {
+	monitor.Register(monitor.New("fairusage", time.Second, Monitor))
+}
+
 
-func Monitor(c *cache.Cache) error {
+func Monitor(ctx context.Context, c *registry.Registry) error {
 	log.Debug("Monitoring fair usage")
 
 	activities := GetFairUsageActivities(c)
 
 	for _, activity := range activities {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if activity.CPUAverage() > threshold {
 			log.Debugf("Activity %v exceeded fair usage threshold", activity.Name())
 			activity.Limit(warnTime, quarantineTime)
@@ -0,0 +1,55 @@
+package fd
+
+import (
+	"sort"
+
+	"github.com/zero-os/0-ork/registry"
+)
+
+type FD interface {
+	registry.Activity
+	FD() (uint64, error)
+	Kill() error
+	Name() string
+}
+
+// holder is an activity with its number of open file descriptors
+type holder struct {
+	FD
+	count uint64
+}
+
+type holders []holder
+
+func (h holders) Len() int { return len(h) }
+
+func (h holders) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h holders) Less(i, j int) bool {
+	return h[i].count < h[j].count
+}
+
+// getHolders returns the activities with their number of open file descriptors sorted in descending order.
+// The file descriptors are only counted here since counting them is too expensive to do on every cache update.
+func getHolders(c *registry.Registry) holders {
+	var candidates []FD
+	c.Range(func(value interface{}) bool {
+		if activity, ok := value.(FD); ok {
+			candidates = append(candidates, activity)
+		}
+		return true
+	})
+
+	activities := make(holders, 0, len(candidates))
+	for _, activity := range candidates {
+		count, err := activity.FD()
+		if err != nil {
+			continue
+		}
+		activities = append(activities, holder{activity, count})
+	}
+	sort.Sort(sort.Reverse(activities))
+	return activities
+}
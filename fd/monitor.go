@@ -0,0 +1,135 @@
+// Package fd implements a monitor of the file descriptors and inodes used by the host
+package fd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/monitor"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// fdThreshold is the fraction of fs.file-max, and of the maximum of the inode table, at which ork should kill
+// the top file descriptor holder
+const fdThreshold float64 = 0.9
+
+var log = logging.MustGetLogger("ORK")
+var killCounter = 0
+
+// readUints returns the numbers of a file of /proc/sys/fs, it fails if there are less than count of them
+func readUints(path string, count int) ([]uint64, error) {
+	contents, err := host.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(contents))
+	if len(fields) < count {
+		return nil, fmt.Errorf("Invalid %v: %v", path, string(contents))
+	}
+	values := make([]uint64, count)
+	for i := range values {
+		if values[i], err = strconv.ParseUint(fields[i], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// readFileNr returns the number of allocated file handles and the maximum as reported by /proc/sys/fs/file-nr
+func readFileNr() (uint64, uint64, error) {
+	// formatted as: allocated unused max
+	values, err := readUints("/proc/sys/fs/file-nr", 3)
+	if err != nil {
+		return 0, 0, err
+	}
+	return values[0] - values[1], values[2], nil
+}
+
+// readInodeNr returns the number of inodes in use and the maximum of the inode table as reported by
+// /proc/sys/fs/inode-nr. fs.inode-max bounds the inode table on the kernels that still provide it, newer kernels
+// grow the table with the memory so it is compared to fs.file-max which the kernel sizes from the memory as well.
+func readInodeNr(maxFiles uint64) (uint64, uint64, error) {
+	// formatted as: nr_inodes nr_free_inodes
+	values, err := readUints("/proc/sys/fs/inode-nr", 2)
+	if err != nil {
+		return 0, 0, err
+	}
+	max := maxFiles
+	if inodeMax, err := readUints("/proc/sys/fs/inode-max", 1); err == nil {
+		max = inodeMax[0]
+	}
+	return values[0] - values[1], max, nil
+}
+
+// checkTables returns whether the file handles and the inodes used are below the defined threshold
+func checkTables() (bool, bool, error) {
+	files, maxFiles, err := readFileNr()
+	if err != nil {
+		log.Error("Error getting file handles")
+		return false, false, err
+	}
+	inodes, maxInodes, err := readInodeNr(maxFiles)
+	if err != nil {
+		log.Error("Error getting inodes")
+		return false, false, err
+	}
+
+	filesOk := float64(files) < float64(maxFiles)*fdThreshold
+	inodesOk := float64(inodes) < float64(maxInodes)*fdThreshold
+	log.Debugf("File handles used: %v out of %v, inodes used: %v out of %v", files, maxFiles, inodes, maxInodes)
+	return filesOk, inodesOk, nil
+}
+
+func init() {
+	monitor.Register(monitor.New("fd", time.Second, Monitor))
+}
+
+// Monitor checks the number of file handles and inodes used by the host and if either exceeds fdThreshold it kills
+// the activity holding the most file descriptors, since open files keep their inodes in use. Nothing is killed
+// once ctx is done.
+func Monitor(ctx context.Context, c *registry.Registry) error {
+	log.Debug("Monitoring file descriptors")
+
+	filesOk, inodesOk, err := checkTables()
+	if err != nil {
+		return err
+	}
+	if filesOk && inodesOk {
+		killCounter = 0
+		return nil
+	}
+	killCounter += 1
+	log.Debugf("File handles or inodes are above threshold and kill counter is %v", killCounter)
+	if killCounter < 5 {
+		return nil
+	}
+	event := utils.FDExhaustion
+	if filesOk {
+		event = utils.InodeExhaustion
+	}
+
+	holders := getHolders(c)
+	if len(holders) == 0 {
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	activ := holders[0]
+	log.Infof("Killing %v holding %v file descriptors", activ.Name(), activ.count)
+	if err := activ.Kill(); err != nil {
+		utils.LogEvent(event, activ.Name(), utils.Error)
+		return err
+	}
+	utils.LogEvent(event, activ.Name(), utils.Success)
+	c.Remove(activ)
+	killCounter = 0
+	return nil
+}
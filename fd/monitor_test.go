@@ -0,0 +1,87 @@
+package fd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/orktest"
+	"github.com/zero-os/0-ork/registry"
+)
+
+// activity is an activity holding a number of file descriptors
+type activity struct {
+	orktest.Activity
+	fds uint64
+}
+
+func (a *activity) FD() (uint64, error) { return a.fds, nil }
+
+// newFakeHost returns a host with a maximum of 1000 file handles using 100 file handles and 100 inodes
+func newFakeHost(t *testing.T) *host.Fake {
+	f := orktest.NewHost(t, func() { killCounter = 0 })
+	setUsage(f, 100, 100)
+	return f
+}
+
+// setUsage sets the numbers of file handles and of inodes in use
+func setUsage(f *host.Fake, files, inodes uint64) {
+	f.Set("/proc/sys/fs/file-nr", fmt.Sprintf("%v\t0\t1000\n", files))
+	f.Set("/proc/sys/fs/inode-nr", fmt.Sprintf("%v\t50\n", inodes+50))
+}
+
+func TestMonitorKillsTopHolder(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		files, inodes uint64
+	}{
+		{"file handles", 950, 100},
+		{"inodes", 100, 950},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFakeHost(t)
+			top := &activity{orktest.Activity{Label: "top"}, 800}
+			other := &activity{orktest.Activity{Label: "other"}, 100}
+			c := registry.New()
+			c.Set(orktest.Kind, top.ID(), top)
+			c.Set(orktest.Kind, other.ID(), other)
+			monitor := func(checks int) {
+				for i := 0; i < checks; i++ {
+					if err := Monitor(context.Background(), c); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			monitor(10)
+			if top.Killed || other.Killed {
+				t.Fatal("expected nothing to be killed below the threshold")
+			}
+
+			// the usage has to stay above the threshold for 5 checks
+			setUsage(f, test.files, test.inodes)
+			monitor(4)
+			if top.Killed {
+				t.Fatal("expected nothing to be killed before the usage stays high")
+			}
+			monitor(1)
+			if !top.Killed || other.Killed {
+				t.Errorf("expected only the top holder to be killed, killed top %v other %v", top.Killed, other.Killed)
+			}
+		})
+	}
+}
+
+func TestInodeMax(t *testing.T) {
+	f := newFakeHost(t)
+	f.Set("/proc/sys/fs/inode-max", "4000\n")
+
+	used, max, err := readInodeNr(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != 100 || max != 4000 {
+		t.Errorf("expected 100 inodes used out of fs.inode-max, got %v out of %v", used, max)
+	}
+}
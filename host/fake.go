@@ -0,0 +1,133 @@
+package host
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fake is an in-memory Source for tests. Directories exist as long as they contain a file or a link and the
+// files written by ORK are recorded so that tests can check its actions.
+type Fake struct {
+	lock    sync.Mutex
+	files   map[string][]byte
+	links   map[string]string
+	written map[string][]string
+}
+
+// NewFake returns an empty Fake
+func NewFake() *Fake {
+	return &Fake{
+		files:   make(map[string][]byte),
+		links:   make(map[string]string),
+		written: make(map[string][]string),
+	}
+}
+
+// Set sets the contents of the file at path
+func (f *Fake) Set(path string, contents string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.files[filepath.Clean(path)] = []byte(contents)
+}
+
+// Link makes path a symbolic link to target
+func (f *Fake) Link(path string, target string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.links[filepath.Clean(path)] = target
+}
+
+// Remove removes the file or link at path or the directory at path and all its files and links
+func (f *Fake) Remove(path string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	path = filepath.Clean(path)
+	for name := range f.files {
+		if name == path || strings.HasPrefix(name, path+"/") {
+			delete(f.files, name)
+		}
+	}
+	for name := range f.links {
+		if name == path || strings.HasPrefix(name, path+"/") {
+			delete(f.links, name)
+		}
+	}
+}
+
+// Written returns the data written to the file at path in order
+func (f *Fake) Written(path string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.written[filepath.Clean(path)]...)
+}
+
+func (f *Fake) ReadFile(path string) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	contents, ok := f.files[filepath.Clean(path)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), contents...), nil
+}
+
+func (f *Fake) ReadDir(path string) ([]string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	prefix := filepath.Clean(path)
+	if prefix != "/" {
+		prefix += "/"
+	}
+	entries := make(map[string]struct{})
+	add := func(name string) {
+		if !strings.HasPrefix(name, prefix) {
+			return
+		}
+		entry := strings.TrimPrefix(name, prefix)
+		if i := strings.IndexByte(entry, '/'); i >= 0 {
+			entry = entry[:i]
+		}
+		entries[entry] = struct{}{}
+	}
+	for name := range f.files {
+		add(name)
+	}
+	for name := range f.links {
+		add(name)
+	}
+	if len(entries) == 0 {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+
+	names := make([]string, 0, len(entries))
+	for entry := range entries {
+		names = append(names, entry)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (f *Fake) WriteFile(path string, data []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	path = filepath.Clean(path)
+	if _, ok := f.files[path]; !ok {
+		return &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	f.files[path] = append([]byte(nil), data...)
+	f.written[path] = append(f.written[path], string(data))
+	return nil
+}
+
+func (f *Fake) Readlink(path string) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	target, ok := f.links[filepath.Clean(path)]
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: path, Err: os.ErrNotExist}
+	}
+	return target, nil
+}
@@ -0,0 +1,132 @@
+// Package host abstracts the procfs and sysfs files ORK reads and writes so that the collectors and the monitors
+// can run against a fixture instead of the running host.
+package host
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Source is where the kernel files are read from and written to, paths are absolute paths on the host
+type Source interface {
+	// ReadFile returns the contents of the file at path
+	ReadFile(path string) ([]byte, error)
+	// ReadDir returns the sorted names of the entries of the directory at path
+	ReadDir(path string) ([]string, error)
+	// WriteFile writes data to the existing file at path, kernel files are never created
+	WriteFile(path string, data []byte) error
+	// Readlink returns the target of the symbolic link at path
+	Readlink(path string) (string, error)
+}
+
+// Dir is a Source rooted at a directory, the running host is Dir("/") and a fixture tree can be used in tests
+type Dir string
+
+// Path returns the path of a host file under the directory
+func (d Dir) Path(path string) string {
+	return filepath.Join(string(d), path)
+}
+
+func (d Dir) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(d.Path(path))
+}
+
+func (d Dir) ReadDir(path string) ([]string, error) {
+	dir, err := os.Open(d.Path(path))
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (d Dir) WriteFile(path string, data []byte) error {
+	f, err := os.OpenFile(d.Path(path), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (d Dir) Readlink(path string) (string, error) {
+	return os.Readlink(d.Path(path))
+}
+
+// source is the Source used by ORK, it is only replaced by tests before the monitors are started
+var source Source = Dir("/")
+
+// Current returns the Source used by ORK
+func Current() Source {
+	return source
+}
+
+// Use makes ORK use s and returns the Source used before, it is meant for tests
+func Use(s Source) Source {
+	previous := source
+	source = s
+	return previous
+}
+
+// ReadFile returns the contents of a file of the host
+func ReadFile(path string) ([]byte, error) {
+	return source.ReadFile(path)
+}
+
+// ReadDir returns the sorted names of the entries of a directory of the host
+func ReadDir(path string) ([]string, error) {
+	return source.ReadDir(path)
+}
+
+// WriteFile writes data to an existing file of the host
+func WriteFile(path string, data []byte) error {
+	return source.WriteFile(path, data)
+}
+
+// Readlink returns the target of a symbolic link of the host
+func Readlink(path string) (string, error) {
+	return source.Readlink(path)
+}
+
+// Exists returns true if path exists on the host, it works for write only files
+func Exists(path string) bool {
+	names, err := source.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	name := filepath.Base(path)
+	i := sort.SearchStrings(names, name)
+	return i < len(names) && names[i] == name
+}
+
+// Glob returns the paths of the host matching pattern, only the last element of pattern may contain wildcards
+func Glob(pattern string) ([]string, error) {
+	dir, name := filepath.Split(pattern)
+	if _, err := filepath.Match(name, ""); err != nil {
+		return nil, err
+	}
+	names, err := source.ReadDir(dir)
+	if err != nil {
+		// like filepath.Glob a missing directory matches nothing
+		return nil, nil
+	}
+
+	var matches []string
+	for _, n := range names {
+		if ok, _ := filepath.Match(name, n); ok {
+			matches = append(matches, filepath.Join(dir, n))
+		}
+	}
+	return matches, nil
+}
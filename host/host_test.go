@@ -0,0 +1,109 @@
+package host
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFake(t *testing.T) {
+	fake := NewFake()
+	fake.Set("/sys/class/net/vx0/uevent", "DEVTYPE=vxlan\n")
+	fake.Set("/sys/class/net/vx0/statistics/tx_bytes", "10\n")
+	fake.Set("/sys/class/net/eth0/uevent", "")
+	defer Use(Use(fake))
+
+	names, err := ReadDir("/sys/class/net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"eth0", "vx0"}) {
+		t.Errorf("expected eth0 and vx0, got %v", names)
+	}
+	if _, err := ReadFile("/sys/class/net/vx1/uevent"); !os.IsNotExist(err) {
+		t.Errorf("expected a missing file not to exist, got %v", err)
+	}
+
+	if err := WriteFile("/sys/class/net/vx0/statistics/tx_bytes", []byte("20")); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile("/sys/class/net/vx0/mtu", []byte("1500")); !os.IsNotExist(err) {
+		t.Errorf("expected writing a missing file to fail, got %v", err)
+	}
+	if contents, _ := ReadFile("/sys/class/net/vx0/statistics/tx_bytes"); string(contents) != "20" {
+		t.Errorf("expected the written value, got %q", contents)
+	}
+	if written := fake.Written("/sys/class/net/vx0/statistics/tx_bytes"); !reflect.DeepEqual(written, []string{"20"}) {
+		t.Errorf("expected the write to be recorded, got %v", written)
+	}
+
+	fake.Link("/sys/class/net/vx0/device", "../../devices/vx0")
+	if target, err := Readlink("/sys/class/net/vx0/device"); err != nil || target != "../../devices/vx0" {
+		t.Errorf("expected the target of the link, got %q %v", target, err)
+	}
+	if !Exists("/sys/class/net/vx0/device") {
+		t.Error("expected the link to be listed in its directory")
+	}
+
+	fake.Remove("/sys/class/net/vx0")
+	if Exists("/sys/class/net/vx0") || !Exists("/sys/class/net/eth0") {
+		t.Error("expected vx0 to be removed and eth0 to be kept")
+	}
+	if _, err := Readlink("/sys/class/net/vx0/device"); !os.IsNotExist(err) {
+		t.Errorf("expected the link to be removed with its directory, got %v", err)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	fake := NewFake()
+	fake.Set("/sys/devices/system/node/node0/meminfo", "")
+	fake.Set("/sys/devices/system/node/node1/meminfo", "")
+	fake.Set("/sys/devices/system/node/possible", "0-1")
+	defer Use(Use(fake))
+
+	matches, err := Glob("/sys/devices/system/node/node[0-9]*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/sys/devices/system/node/node0", "/sys/devices/system/node/node1"}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Errorf("expected %v, got %v", expected, matches)
+	}
+	if matches, err := Glob("/sys/kernel/mm/hugepages/hugepages-*kB"); err != nil || len(matches) != 0 {
+		t.Errorf("expected a missing directory to match nothing, got %v %v", matches, err)
+	}
+}
+
+func TestStatistics(t *testing.T) {
+	fake := NewFake()
+	fake.Set("/proc/stat", "cpu  100 20 30 400 50 6 7 8 9 0\ncpu0 100 20 30 400 50 6 7 8 9 0\nprocs_blocked 0\n")
+	fake.Set("/proc/meminfo", "MemTotal:       16000000 kB\nMemFree:          800000 kB\n"+
+		"MemAvailable:    2048000 kB\nSwapTotal:       1024000 kB\nSwapFree:         512000 kB\n")
+	fake.Set("/proc/vmstat", "pswpin 10\npswpout 20\n")
+	defer Use(Use(fake))
+
+	times, err := ReadCPUTimes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (CPUTimes{100, 20, 30, 400, 50, 6, 7, 8}); times != expected || times.Total() != 621 {
+		t.Errorf("expected %+v, got %+v", expected, times)
+	}
+
+	available, err := AvailableMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available != 2000 {
+		t.Errorf("expected 2000MB available, got %v", available)
+	}
+
+	swap, err := ReadSwap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := SwapStat{Total: 1024000 * 1024, Free: 512000 * 1024, In: 10 * pageSize, Out: 20 * pageSize}
+	if swap != expected {
+		t.Errorf("expected %+v, got %+v", expected, swap)
+	}
+}
@@ -0,0 +1,47 @@
+package host
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// mountEscapes decodes the characters mountinfo escapes in mount points
+var mountEscapes = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// MountPoints returns the mount points of the host as reported by /proc/self/mountinfo
+func MountPoints() ([]string, error) {
+	contents, err := source.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	// the fifth field is the mount point: id parent major:minor root mount-point options ...
+	var mounts []string
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mounts = append(mounts, mountEscapes.Replace(fields[4]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// MountOf returns the mount point among mounts the absolute path is on, that is the longest one containing it
+func MountOf(mounts []string, path string) string {
+	var mount string
+	for _, m := range mounts {
+		if len(m) <= len(mount) {
+			continue
+		}
+		if path == m || strings.HasPrefix(path, strings.TrimSuffix(m, "/")+"/") {
+			mount = m
+		}
+	}
+	return mount
+}
@@ -0,0 +1,135 @@
+package host
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var pageSize = uint64(os.Getpagesize())
+
+// CPUTimes holds the time in clock ticks all cpus spent in each state since boot as reported by /proc/stat.
+// The time spent running guests is included in User and Nice.
+type CPUTimes struct {
+	User    uint64
+	Nice    uint64
+	System  uint64
+	Idle    uint64
+	Iowait  uint64
+	Irq     uint64
+	Softirq uint64
+	Steal   uint64
+}
+
+// Total returns the time spent in all states
+func (t CPUTimes) Total() uint64 {
+	return t.User + t.Nice + t.System + t.Idle + t.Iowait + t.Irq + t.Softirq + t.Steal
+}
+
+// ReadCPUTimes returns the time all cpus combined spent in each state
+func ReadCPUTimes() (CPUTimes, error) {
+	contents, err := ReadFile("/proc/stat")
+	if err != nil {
+		return CPUTimes{}, err
+	}
+
+	// the first line is formatted as: cpu user nice system idle iowait irq softirq steal guest guest_nice
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "cpu" {
+			continue
+		}
+		var values [8]uint64
+		for i := range values {
+			if i+1 >= len(fields) {
+				break
+			}
+			if values[i], err = strconv.ParseUint(fields[i+1], 10, 64); err != nil {
+				return CPUTimes{}, fmt.Errorf("Error parsing cpu times from /proc/stat: %v", err)
+			}
+		}
+		return CPUTimes{values[0], values[1], values[2], values[3], values[4], values[5], values[6], values[7]}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return CPUTimes{}, err
+	}
+	return CPUTimes{}, fmt.Errorf("cpu times are missing from /proc/stat")
+}
+
+// readCounters parses a file made of lines formatted as: key value [unit]
+func readCounters(path string) (map[string]uint64, error) {
+	contents, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		key := strings.TrimSuffix(fields[0], ":")
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing %v from %v: %v", key, path, err)
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// Meminfo returns the values of /proc/meminfo, the sizes are in kilo byte
+func Meminfo() (map[string]uint64, error) {
+	return readCounters("/proc/meminfo")
+}
+
+// Vmstat returns the counters of /proc/vmstat
+func Vmstat() (map[string]uint64, error) {
+	return readCounters("/proc/vmstat")
+}
+
+// AvailableMemory returns the memory in MB available for starting new applications without swapping.
+// It is estimated from the free memory and the page cache on kernels that don't report MemAvailable.
+func AvailableMemory() (uint64, error) {
+	values, err := Meminfo()
+	if err != nil {
+		return 0, err
+	}
+	available, ok := values["MemAvailable"]
+	if !ok {
+		available = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+	return available / 1024, nil
+}
+
+// SwapStat holds the usage of swap
+type SwapStat struct {
+	Total uint64 // size of the swap in bytes
+	Free  uint64 // free swap in bytes
+	In    uint64 // bytes swapped in since boot
+	Out   uint64 // bytes swapped out since boot
+}
+
+// ReadSwap returns the usage of swap as reported by /proc/meminfo and /proc/vmstat
+func ReadSwap() (SwapStat, error) {
+	meminfo, err := Meminfo()
+	if err != nil {
+		return SwapStat{}, err
+	}
+	vmstat, err := Vmstat()
+	if err != nil {
+		return SwapStat{}, err
+	}
+	return SwapStat{
+		Total: meminfo["SwapTotal"] * 1024,
+		Free:  meminfo["SwapFree"] * 1024,
+		In:    vmstat["pswpin"] * pageSize,
+		Out:   vmstat["pswpout"] * pageSize,
+	}, nil
+}
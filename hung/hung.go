@@ -0,0 +1,33 @@
+package hung
+
+import (
+	"time"
+
+	"github.com/zero-os/0-ork/registry"
+)
+
+type Blocked interface {
+	registry.Activity
+	Blocked() time.Duration
+	WaitChannel() string
+	Mount() string
+	KillOriginTree() error
+	Name() string
+}
+
+// GetBlockedActivities returns the activities that have been in uninterruptible sleep for at least timeout
+func GetBlockedActivities(c *registry.Registry, timeout time.Duration) []Blocked {
+	activities := make([]Blocked, 0)
+
+	c.Range(func(value interface{}) bool {
+		activity, ok := value.(Blocked)
+		if !ok {
+			return true
+		}
+		if blocked := activity.Blocked(); blocked > 0 && blocked >= timeout {
+			activities = append(activities, activity)
+		}
+		return true
+	})
+	return activities
+}
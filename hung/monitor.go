@@ -0,0 +1,164 @@
+// Package hung implements a monitor of the tasks stuck in uninterruptible sleep
+package hung
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/monitor"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// blockedThreshold is the number of blocked tasks at which the host is considered in a blocked tasks storm
+const blockedThreshold uint64 = 100
+
+// hungTimeout is the time after which a task in uninterruptible sleep is considered hung
+const hungTimeout = 2 * time.Minute
+
+var log = logging.MustGetLogger("ORK")
+var blockedCounter = 0
+
+// hung holds the ids of the activities a warning was emitted for
+var hung = make(map[string]struct{})
+
+// killed holds the ids of the activities that were killed while hung
+var killed = make(map[string]struct{})
+
+// readBlocked returns the number of tasks blocked waiting for I/O as reported by procs_blocked in /proc/stat
+func readBlocked() (uint64, error) {
+	contents, err := host.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "procs_blocked" {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("procs_blocked is missing from /proc/stat")
+}
+
+// resource is what a task is blocked on: the kernel function it sleeps in and the mount point of the files it uses
+type resource struct {
+	wchan string
+	mount string
+}
+
+func (r resource) String() string {
+	if r.mount == "" {
+		return r.wchan
+	}
+	return fmt.Sprintf("%v on %v", r.wchan, r.mount)
+}
+
+// commonResource returns the resource most of the activities are blocked on and the activities blocked on it
+func commonResource(activities []Blocked) (resource, []Blocked) {
+	resources := make(map[resource][]Blocked)
+	for _, activ := range activities {
+		r := resource{activ.WaitChannel(), activ.Mount()}
+		resources[r] = append(resources[r], activ)
+	}
+
+	var common resource
+	for r, blocked := range resources {
+		if len(blocked) > len(resources[common]) {
+			common = r
+		}
+	}
+	return common, resources[common]
+}
+
+func init() {
+	monitor.Register(monitor.New("hung", time.Second, Monitor))
+}
+
+// Monitor checks the number of blocked tasks and the tasks hung in uninterruptible sleep and reports the
+// resource they are waiting for, identified by the wait channel and the mount point of the files they use.
+// If enabled, the process trees the tasks blocked on the common resource originate from are killed so that
+// they stop issuing requests to it, which only frees the hung tasks sleeping in a killable wait.
+// Nothing is killed once ctx is done.
+func Monitor(ctx context.Context, c *registry.Registry) error {
+	log.Debug("Monitoring hung tasks")
+
+	blocked, err := readBlocked()
+	if err != nil {
+		log.Errorf("Error getting blocked tasks: %v", err)
+		return err
+	}
+	if blocked < blockedThreshold {
+		blockedCounter = 0
+	} else {
+		blockedCounter += 1
+	}
+
+	activities := GetBlockedActivities(c, hungTimeout)
+	flagged := make(map[string]struct{}, len(activities))
+	for _, activ := range activities {
+		flagged[activ.ID()] = struct{}{}
+		if _, ok := killed[activ.ID()]; ok {
+			log.Warningf("Killing %v hung in %v was not effective", activ.Name(), activ.WaitChannel())
+		}
+	}
+	for id := range killed {
+		if _, ok := flagged[id]; !ok {
+			delete(killed, id)
+		}
+	}
+
+	if len(activities) == 0 {
+		hung = flagged
+		if blockedCounter == 5 {
+			log.Warningf("%v tasks are blocked", blocked)
+			utils.LogEvent(utils.HungTasks, "host", utils.Warning)
+		}
+		return nil
+	}
+
+	common, waiting := commonResource(activities)
+	for _, activ := range activities {
+		if _, ok := hung[activ.ID()]; ok {
+			continue
+		}
+		log.Warningf("Activity %v has been blocked in %v for %v", activ.Name(), activ.WaitChannel(), activ.Blocked())
+		utils.LogEvent(utils.HungTasks, activ.Name(), utils.Warning)
+	}
+	hung = flagged
+	if blockedCounter == 5 {
+		log.Warningf("%v tasks are blocked, %v hung tasks are waiting in %v", blocked, len(waiting), common)
+		utils.LogToKernel("ORK: %v tasks are blocked, %v hung tasks are waiting in %v\n", blocked, len(waiting), common)
+		utils.LogEvent(utils.HungTasks, common.String(), utils.Warning)
+	}
+
+	if !utils.HungKill() {
+		return nil
+	}
+	for _, activ := range waiting {
+		if _, ok := killed[activ.ID()]; ok {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		killed[activ.ID()] = struct{}{}
+		if err := activ.KillOriginTree(); err != nil {
+			utils.LogEvent(utils.HungTasks, activ.Name(), utils.Error)
+			continue
+		}
+		utils.LogEvent(utils.HungTasks, activ.Name(), utils.Success)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package leak
+
+import (
+	"sort"
+
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+type Leak interface {
+	registry.Activity
+	MemoryGrowth() utils.Growth
+	Kill() error
+	Name() string
+}
+
+type Activities []Leak
+
+func (a Activities) Len() int { return len(a) }
+
+func (a Activities) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+
+func (a Activities) Less(i, j int) bool {
+	return a[i].MemoryGrowth().Rate < a[j].MemoryGrowth().Rate
+}
+
+// GetLeakActivities returns the activities whose memory grew monotonically faster than rate
+// sorted by growth rate in descending order.
+func GetLeakActivities(c *registry.Registry, rate float64) Activities {
+	activities := make(Activities, 0)
+
+	c.Range(func(value interface{}) bool {
+		activity, ok := value.(Leak)
+		if !ok {
+			return true
+		}
+		if growth := activity.MemoryGrowth(); growth.Monotonic && growth.Rate >= rate {
+			activities = append(activities, activity)
+		}
+		return true
+	})
+	sort.Sort(sort.Reverse(activities))
+	return activities
+}
@@ -0,0 +1,99 @@
+// Package leak implements a memory leak detector
+package leak
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/monitor"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// exhaustionWarning is the forecasted time to memory exhaustion under which ORK warns about it
+const exhaustionWarning = time.Hour
+
+var log = logging.MustGetLogger("ORK")
+
+// hostTrend tracks the available memory of the host in MB
+var hostTrend = utils.NewTrend(10*time.Second, 10*time.Minute)
+
+// leaking holds the ids of the activities a leak warning was emitted for
+var leaking = make(map[string]struct{})
+var exhausting = false
+
+// forecastExhaustion returns the time left until the available memory runs out if it keeps decreasing
+// at the current rate.
+func forecastExhaustion(available float64) time.Duration {
+	growth := hostTrend.Growth()
+	if !hostTrend.Full() || growth.Rate >= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(available / -growth.Rate * float64(time.Minute))
+}
+
+func init() {
+	monitor.Register(monitor.New("leak", time.Second, Monitor))
+}
+
+// Monitor flags activities whose memory grows monotonically faster than the configured rate and
+// forecasts when the host will run out of memory. The fastest leaking activity is killed if the
+// forecast falls under the configured deadline. Nothing is killed once ctx is done. The history of the host is
+// discarded after a kill so that nothing else is killed before a new forecast covers a whole window.
+func Monitor(ctx context.Context, c *registry.Registry) error {
+	log.Debug("Monitoring memory leaks")
+
+	availableMemory, err := host.AvailableMemory()
+	if err != nil {
+		log.Error("Error getting available memory")
+		return err
+	}
+	available := float64(availableMemory)
+	hostTrend.Add(time.Now(), available)
+	forecast := forecastExhaustion(available)
+
+	if forecast < exhaustionWarning {
+		if !exhausting {
+			log.Warningf("Memory is forecasted to be exhausted in %v", forecast)
+			utils.LogEvent(utils.MemoryExhaustion, "host", utils.Warning)
+			exhausting = true
+		}
+	} else {
+		exhausting = false
+	}
+
+	activities := GetLeakActivities(c, utils.LeakRate())
+	flagged := make(map[string]struct{}, len(activities))
+	for _, activ := range activities {
+		flagged[activ.ID()] = struct{}{}
+		if _, ok := leaking[activ.ID()]; ok {
+			continue
+		}
+		log.Warningf("Activity %v memory is growing by %.2fMB per minute", activ.Name(), activ.MemoryGrowth().Rate)
+		utils.LogEvent(utils.MemoryLeak, activ.Name(), utils.Warning)
+	}
+	leaking = flagged
+
+	deadline := utils.LeakDeadline()
+	if deadline == 0 || forecast >= deadline || len(activities) == 0 {
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	activ := activities[0]
+	log.Infof("Memory is forecasted to be exhausted in %v, killing leaking activity %v", forecast, activ.Name())
+	if err := activ.Kill(); err != nil {
+		utils.LogEvent(utils.MemoryLeak, activ.Name(), utils.Error)
+		return err
+	}
+	utils.LogEvent(utils.MemoryLeak, activ.Name(), utils.Success)
+	c.Remove(activ)
+	delete(leaking, activ.ID())
+	hostTrend.Reset()
+	return nil
+}
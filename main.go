@@ -1,68 +1,66 @@
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
 	"time"
 
 	"github.com/op/go-logging"
-	"github.com/patrickmn/go-cache"
 	"github.com/urfave/cli"
-	"github.com/zero-os/0-ork/cpu"
+	_ "github.com/zero-os/0-ork/cpu"
 	"github.com/zero-os/0-ork/domain"
-	"github.com/zero-os/0-ork/fairusage"
-	"github.com/zero-os/0-ork/memory"
-	"github.com/zero-os/0-ork/network"
+	_ "github.com/zero-os/0-ork/fairusage"
+	_ "github.com/zero-os/0-ork/fd"
+	"github.com/zero-os/0-ork/host"
+	_ "github.com/zero-os/0-ork/hung"
+	_ "github.com/zero-os/0-ork/leak"
+	_ "github.com/zero-os/0-ork/memory"
+	"github.com/zero-os/0-ork/monitor"
+	_ "github.com/zero-os/0-ork/network"
 	"github.com/zero-os/0-ork/nic"
+	_ "github.com/zero-os/0-ork/pids"
 	"github.com/zero-os/0-ork/process"
+	"github.com/zero-os/0-ork/registry"
+	_ "github.com/zero-os/0-ork/slab"
 	"github.com/zero-os/0-ork/utils"
+	"github.com/zero-os/0-ork/watchdog"
 )
 
 var log = logging.MustGetLogger("ORK")
 
-func monitorMemory(c *cache.Cache) {
-	for {
-		if err := memory.Monitor(c); err != nil {
-			log.Error(err)
-		}
-		time.Sleep(time.Second)
-	}
-}
+// memoryLimit is the soft limit of the memory used by the go runtime, the garbage collector
+// works harder when it is reached instead of growing the heap.
+const memoryLimit = 64 * 1024 * 1024
 
-func monitorCPU(c *cache.Cache) {
-	for {
-		if err := cpu.Monitor(c); err != nil {
-			log.Error(err)
-		}
-		time.Sleep(time.Second)
+// protect makes sure ORK keeps running when the host is out of memory: it is excluded from the kernel oom killer,
+// its memory is locked so that it is never swapped out and the kernel log is opened once for all so that
+// logging an action doesn't need to open a file.
+func protect() {
+	if err := host.WriteFile("/proc/self/oom_score_adj", []byte("-1000")); err != nil {
+		log.Errorf("Error setting oom_score_adj: %v", err)
 	}
-}
-
-func monitorNetwork(c *cache.Cache) {
-	for {
-		if err := network.Monitor(c); err != nil {
-			log.Error(err)
-		}
-		time.Sleep(time.Second)
+	if err := syscall.Mlockall(syscall.MCL_CURRENT | syscall.MCL_FUTURE); err != nil {
+		log.Errorf("Error locking memory: %v", err)
 	}
-}
-
-func monitorFairUsage(c *cache.Cache) {
-	for {
-		if err := fairusage.Monitor(c); err != nil {
-			log.Error(err)
-		}
-		time.Sleep(time.Second)
+	if err := utils.OpenKernelLog(); err != nil {
+		log.Errorf("Error opening /dev/kmsg: %v", err)
 	}
+	debug.SetMemoryLimit(memoryLimit)
 }
 
-func updateCache(c *cache.Cache) {
-	for {
-		domain.UpdateCache(c)
-		process.UpdateCache(c)
-		nic.UpdateCache(c)
-
-		time.Sleep(time.Second)
+// updateCache collects the activities monitored by ORK, it stops between collections once ctx is done
+func updateCache(ctx context.Context, c *registry.Registry) error {
+	c.Purge()
+	for _, collect := range []func(*registry.Registry){domain.UpdateCache, process.UpdateCache, nic.UpdateCache} {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		collect(c)
 	}
+	return nil
 }
 
 func main() {
@@ -81,9 +79,14 @@ func main() {
 			Value: "INFO",
 			Usage: "log level",
 		},
+		cli.StringFlag{
+			Name:  "health",
+			Value: "",
+			Usage: "address to serve the health endpoint on, disabled if empty",
+		},
 	}
-	app.Action = func(context *cli.Context) {
-		level, err := logging.LogLevel(context.String("level"))
+	app.Action = func(cliContext *cli.Context) {
+		level, err := logging.LogLevel(cliContext.String("level"))
 		if err != nil {
 			log.Error(err)
 			os.Exit(1)
@@ -93,26 +96,35 @@ func main() {
 		backendLeveled.SetLevel(level, "")
 		logging.SetBackend(backendLeveled)
 
-		c := cache.New(cache.NoExpiration, time.Minute)
+		protect()
+		c := registry.New()
+		c.Listen(func(event registry.Event, key string, value interface{}) {
+			log.Debugf("Activity %v %v", key, event)
+		})
 
 		log.Info("Starting ORK....")
-		go updateCache(c)
-
-		if utils.MonitorCPU() {
-			go monitorCPU(c)
-		}
-		if utils.MonitorMem() {
-			go monitorMemory(c)
-		}
-		if utils.MonitorNetwork() {
-			go monitorNetwork(c)
-		}
-		if utils.MonitorFairUsage() {
-			go monitorFairUsage(c)
+		ctx, cancel := context.WithCancel(context.Background())
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			log.Infof("Received %v, stopping ORK", sig)
+			cancel()
+		}()
+
+		w := watchdog.New()
+		monitor.Go(ctx, w, monitor.New("cache", time.Second, updateCache), c)
+		monitor.Start(ctx, w, c)
+
+		if address := cliContext.String("health"); address != "" {
+			go func() {
+				if err := w.ServeHealth(address); err != nil {
+					log.Errorf("Error serving health endpoint: %v", err)
+				}
+			}()
 		}
 
-		//wait
-		select {}
+		w.Supervise(ctx)
 	}
 
 	app.Run(os.Args)
@@ -0,0 +1,235 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+const hugePagesPath = "/sys/kernel/mm/hugepages"
+
+// hugePagesThreshold is the value in MB of free hugepages of a pool at which the pool is considered exhausted,
+// it is scaled down for small pools and rounded to whole pages, see hugePagesPool.threshold
+const hugePagesThreshold uint64 = 1024
+
+// hugePagesSustain is the number of consecutive checks the hugepages of the host have to be exhausted under
+// pressure before ORK destroys a domain
+const hugePagesSustain = 10
+
+type HugePages interface {
+	registry.Activity
+	HugePages() uint64
+	Kill() error
+	Name() string
+}
+
+type HugePagesActivities []HugePages
+
+func (a HugePagesActivities) Len() int { return len(a) }
+
+func (a HugePagesActivities) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+
+func (a HugePagesActivities) Less(i, j int) bool {
+	return a[i].HugePages() < a[j].HugePages()
+}
+
+// GetHugePagesActivities returns the activities backed by hugepages sorted by their hugepages usage in
+// descending order.
+func GetHugePagesActivities(c *registry.Registry) HugePagesActivities {
+	activities := make(HugePagesActivities, 0)
+
+	c.Range(func(value interface{}) bool {
+		if activity, ok := value.(HugePages); ok && activity.HugePages() > 0 {
+			activities = append(activities, activity)
+		}
+		return true
+	})
+	sort.Sort(sort.Reverse(activities))
+	return activities
+}
+
+// hugePagesPool describes a pool of hugepages of a single size
+type hugePagesPool struct {
+	size     uint64 // size of a page in kilo byte
+	total    uint64
+	free     uint64
+	reserved uint64
+	surplus  uint64
+}
+
+// available returns the memory in MB of the pages of the pool that are neither used nor reserved
+func (p hugePagesPool) available() uint64 {
+	if p.reserved >= p.free {
+		return 0
+	}
+	return (p.free - p.reserved) * p.size / 1024
+}
+
+// pressured returns true if no page of the pool is left for new mappings while more pages are demanded from it,
+// that is the free pages are all reserved by mappings that didn't fault them in yet or surplus pages had to be
+// allocated beyond the pool. A fully allocated pool without reservations nor surplus pages is the normal state
+// of a host running domains backed by hugepages.
+func (p hugePagesPool) pressured() bool {
+	return p.available() == 0 && (p.reserved > 0 || p.surplus > 0)
+}
+
+// threshold returns the memory in MB under which the pool is considered exhausted. It is hugePagesThreshold
+// capped to a tenth of the pool, so that a small pool isn't exhausted as soon as most of it is allocated,
+// and at least one page, so that a pool of gigantic pages is only exhausted when no page is left.
+func (p hugePagesPool) threshold() uint64 {
+	pages := hugePagesThreshold * 1024 / p.size
+	if max := p.total / 10; pages > max {
+		pages = max
+	}
+	if pages == 0 {
+		pages = 1
+	}
+	return pages * p.size / 1024
+}
+
+func (p hugePagesPool) String() string {
+	return fmt.Sprintf("%vkB", p.size)
+}
+
+func readUint(path string) (uint64, error) {
+	contents, err := host.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(contents)), 10, 64)
+}
+
+// readHugePagesPools returns the hugepages pools found in dir. The reserved pages are only reported globally,
+// so they are left to zero for the pools of a NUMA node.
+func readHugePagesPools(dir string) ([]hugePagesPool, error) {
+	paths, err := host.Glob(filepath.Join(dir, "hugepages-*kB"))
+	if err != nil {
+		return nil, err
+	}
+
+	pools := make([]hugePagesPool, 0, len(paths))
+	for _, path := range paths {
+		size := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "hugepages-"), "kB")
+		pool := hugePagesPool{}
+		if pool.size, err = strconv.ParseUint(size, 10, 64); err != nil {
+			return nil, err
+		}
+		if pool.total, err = readUint(filepath.Join(path, "nr_hugepages")); err != nil {
+			return nil, err
+		}
+		if pool.free, err = readUint(filepath.Join(path, "free_hugepages")); err != nil {
+			return nil, err
+		}
+		if reserved, err := readUint(filepath.Join(path, "resv_hugepages")); err == nil {
+			pool.reserved = reserved
+		}
+		if surplus, err := readUint(filepath.Join(path, "surplus_hugepages")); err == nil {
+			pool.surplus = surplus
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+// exhaustedPools holds the pools a warning was emitted for, keyed by node and page size
+var exhaustedPools = make(map[string]struct{})
+
+// hugePagesCounter is the number of consecutive checks a hugepages pool of the host was exhausted under pressure
+var hugePagesCounter = 0
+
+// checkPools emits an event for each pool of location that got exhausted and returns the exhausted pools
+func checkPools(location string, pools []hugePagesPool, exhausted map[string]struct{}) []hugePagesPool {
+	var result []hugePagesPool
+	for _, pool := range pools {
+		if pool.total == 0 || pool.available() >= pool.threshold() {
+			continue
+		}
+		result = append(result, pool)
+
+		key := fmt.Sprintf("%v/%v", location, pool)
+		exhausted[key] = struct{}{}
+		if _, ok := exhaustedPools[key]; ok {
+			continue
+		}
+		log.Warningf("Hugepages pool %v of %v is exhausted: %vMB available", pool, location, pool.available())
+		utils.LogToKernel("ORK: hugepages pool %v of %v is exhausted: %vMB available\n", pool, location, pool.available())
+		utils.LogEvent(utils.HugePagesExhaustion, key, utils.Warning)
+	}
+	return result
+}
+
+// monitorHugePages checks the hugepages pools of the host and of each NUMA node. Their exhaustion is independent
+// of the normal memory pressure since killing processes can't free hugepages, and only the domains backed by
+// hugepages can give them back. Exhausted pools are reported. A fully allocated pool is the normal state of a host
+// running domains backed by hugepages, so the domain using the most hugepages is only destroyed if killing is enabled
+// for hugepages, and a pool of the host stays exhausted under pressure for hugePagesSustain checks.
+func monitorHugePages(ctx context.Context, c *registry.Registry) error {
+	exhausted := make(map[string]struct{})
+	defer func() {
+		exhaustedPools = exhausted
+	}()
+
+	pools, err := readHugePagesPools(hugePagesPath)
+	if err != nil {
+		log.Errorf("Error reading hugepages pools: %v", err)
+		return err
+	}
+	exhaustedHost := checkPools("host", pools, exhausted)
+
+	nodes, err := host.Glob(filepath.Join(nodesPath, "node[0-9]*"))
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		pools, err := readHugePagesPools(filepath.Join(node, "hugepages"))
+		if err != nil {
+			log.Errorf("Error reading hugepages pools of %v: %v", filepath.Base(node), err)
+			continue
+		}
+		checkPools(filepath.Base(node), pools, exhausted)
+	}
+
+	pressured := false
+	for _, pool := range exhaustedHost {
+		pressured = pressured || pool.pressured()
+	}
+	if !pressured {
+		hugePagesCounter = 0
+		return nil
+	}
+	hugePagesCounter += 1
+	if !utils.HugePagesKill() {
+		return nil
+	}
+	log.Debugf("Hugepages pools %v are exhausted under pressure and kill counter is %v", exhaustedHost, hugePagesCounter)
+	if hugePagesCounter < hugePagesSustain {
+		return nil
+	}
+
+	activities := GetHugePagesActivities(c)
+	if len(activities) == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	activ := activities[0]
+	log.Infof("Hugepages pools %v are exhausted, killing %v using %vMB of hugepages", exhaustedHost, activ.Name(), activ.HugePages())
+	if err := activ.Kill(); err != nil {
+		utils.LogEvent(utils.HugePagesExhaustion, activ.Name(), utils.Error)
+		return err
+	}
+	utils.LogEvent(utils.HugePagesExhaustion, activ.Name(), utils.Success)
+	c.Remove(activ)
+	hugePagesCounter = 0
+	return nil
+}
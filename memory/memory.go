@@ -1,17 +1,26 @@
 package memory
 
 import (
+	"fmt"
+	"io"
 	"sort"
 
-	"github.com/patrickmn/go-cache"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
 )
 
 type Memory interface {
+	registry.Activity
 	Memory() uint64
 	Kill() error
 	Name() string
 }
 
+// Accounting is implemented by activities that report a detailed memory accounting
+type Accounting interface {
+	MemoryUsage() utils.MemoryUsage
+}
+
 type Activities []Memory
 
 func (a Activities) Len() int { return len(a) }
@@ -24,15 +33,88 @@ func (a Activities) Less(i, j int) bool {
 	return a[i].Memory() < a[j].Memory()
 }
 
-func GetMemoryActivities(c *cache.Cache) Activities {
-	items := c.Items()
-	activities := make(Activities, 0, c.ItemCount())
+// Total returns the sum of the memory consumed by the activities
+func (a Activities) Total() uint64 {
+	var total uint64
+	for _, activity := range a {
+		total += activity.Memory()
+	}
+	return total
+}
 
-	for _, item := range items {
-		if activity, ok := item.Object.(Memory); ok {
-			activities = append(activities, activity)
+// Without returns the activities other than activity
+func (a Activities) Without(activity Memory) Activities {
+	without := make(Activities, 0, len(a))
+	for _, activ := range a {
+		if activ != activity {
+			without = append(without, activ)
 		}
 	}
+	return without
+}
+
+// Names returns the names of the activities
+func (a Activities) Names() []string {
+	names := make([]string, 0, len(a))
+	for _, activity := range a {
+		names = append(names, activity.Name())
+	}
+	return names
+}
+
+// Format writes the names of the activities the way the list returned by Names is formatted without building
+// that list, so that logging the victims of a kill writes their names straight to the buffer of the message
+func (a Activities) Format(f fmt.State, verb rune) {
+	io.WriteString(f, "[")
+	for i, activity := range a {
+		if i > 0 {
+			io.WriteString(f, " ")
+		}
+		io.WriteString(f, activity.Name())
+	}
+	io.WriteString(f, "]")
+}
+
+func GetMemoryActivities(c *registry.Registry) Activities {
+	activities := make(Activities, 0, c.Len())
+
+	c.Range(func(value interface{}) bool {
+		if activity, ok := value.(Memory); ok {
+			activities = append(activities, activity)
+		}
+		return true
+	})
 	sort.Sort(sort.Reverse(activities))
 	return activities
 }
+
+// planVictims returns the smallest set of activities whose combined memory covers the deficit.
+// activities must be sorted by memory in descending order.
+// Taking the biggest activities first gives the minimum number of victims, the last victim
+// is then replaced by the smallest activity that still covers the remaining deficit so that
+// no more memory than needed is reclaimed.
+// If all activities together can't cover the deficit, all of them are returned. No activity is returned
+// if there is no deficit.
+func planVictims(activities Activities, deficit uint64) Activities {
+	if deficit == 0 {
+		return nil
+	}
+	var reclaim uint64
+	for i, activity := range activities {
+		if reclaim+activity.Memory() < deficit {
+			reclaim += activity.Memory()
+			continue
+		}
+
+		// activities[i] is the first activity that covers the rest of the deficit,
+		// look for the smallest one after it that does as well.
+		last := i
+		for j := i + 1; j < len(activities) && reclaim+activities[j].Memory() >= deficit; j++ {
+			last = j
+		}
+		plan := make(Activities, i, i+1)
+		copy(plan, activities[:i])
+		return append(plan, activities[last])
+	}
+	return activities
+}
@@ -2,27 +2,62 @@
 package memory
 
 import (
+	"context"
+	"time"
+
 	"github.com/op/go-logging"
-	"github.com/patrickmn/go-cache"
-	"github.com/shirou/gopsutil/mem"
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/monitor"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/slab"
+	"github.com/zero-os/0-ork/utils"
 )
 
 // memoryThreshold is the value in MB at which ORK should free-up memory
 const memoryThreshold uint64 = 100
 
+// recoveryTarget is the value in MB of available memory ORK should recover when freeing-up memory
+const recoveryTarget uint64 = 300
+
+// releaseDelay is the longest time given to the kernel to release the memory of a killed activity before the
+// memory deficit is measured again
+const releaseDelay = 2 * time.Second
+
+// releasePoll is the interval at which the available memory is measured while waiting for a release
+const releasePoll = 100 * time.Millisecond
+
+// maxKills is the number of activities killed in a single run, the next run kills more if needed
+const maxKills = 10
+
 var killCounter = 0
 
 var log = logging.MustGetLogger("ORK")
 
+// availableMemory returns the available memory in MB
+func availableMemory() (uint64, error) {
+	available, err := host.AvailableMemory()
+	if err != nil {
+		log.Error("Error getting available memory")
+		return 0, err
+	}
+	return available, nil
+}
+
+// memoryDeficit returns the memory in MB missing to reach recoveryTarget
+func memoryDeficit(available uint64) uint64 {
+	if available >= recoveryTarget {
+		return 0
+	}
+	return recoveryTarget - available
+}
+
 // isMemoryOk returns true if the available is above memoryThreshold
 // and false otherwise
 func isMemoryOk() (bool, error) {
-	v, err := mem.VirtualMemory()
+	availableMem, err := availableMemory()
 	if err != nil {
-		log.Error("Error getting available memory")
 		return false, err
 	}
-	availableMem := v.Available / (1024 * 1024)
 	if availableMem > memoryThreshold {
 		killCounter = 0
 		log.Debugf("Memory available is higher than threshold: %v", availableMem)
@@ -40,30 +75,123 @@ func isMemoryOk() (bool, error) {
 
 }
 
-// Monitor checks the memory consumption and if the available memory is below memoryThreshold it kills
-// activities until available memory is more than
-func Monitor(c *cache.Cache) error {
+// waitRelease waits until the available memory returned by measure grows from available by the released MB,
+// covers the deficit or until releaseDelay passes, and returns the available memory in MB.
+func waitRelease(measure func() (uint64, error), available uint64, released uint64) (uint64, error) {
+	deadline := time.Now().Add(releaseDelay)
+	for {
+		current, err := measure()
+		if err != nil {
+			return 0, err
+		}
+		if current >= available+released || memoryDeficit(current) == 0 || !time.Now().Before(deadline) {
+			return current, nil
+		}
+		time.Sleep(releasePoll)
+	}
+}
+
+func init() {
+	monitor.Register(monitor.New("mem", time.Second, Monitor))
+}
+
+// Monitor checks the memory consumption and if the available memory is below memoryThreshold it asks the kernel
+// to reclaim caches and if that is not enough it kills the smallest set of activities that recovers enough memory
+// to reach recoveryTarget.
+// Memory pressure on a single NUMA node and sustained heavy swapping are treated as memory emergencies as well.
+// The hugepages pools are monitored separately from the normal memory. Nothing is killed once ctx is done.
+func Monitor(ctx context.Context, c *registry.Registry) error {
 	log.Debug("Monitoring memory")
 
+	if err := monitorHugePages(ctx, c); err != nil {
+		log.Errorf("Error monitoring hugepages: %v", err)
+	}
+
 	memOk, err := isMemoryOk()
 	if err != nil {
 		return err
 	}
 	if memOk == true {
-		return nil
+		if err := monitorNUMA(ctx, c); err != nil {
+			return err
+		}
+		return monitorSwap(ctx, c)
 	}
 
-	activities := GetMemoryActivities(c)
+	availableMem, err := availableMemory()
+	if err != nil {
+		return err
+	}
 
-	for i := 0; i < len(activities) && memOk == false; i++ {
-		activ := activities[i]
-		if err = activ.Kill(); err == nil {
-			c.Delete(activ.Name())
+	// Try to recover memory without killing anything first
+	if reclaimed, err := reclaim(memoryDeficit(availableMem)); err != nil {
+		return err
+	} else if reclaimed {
+		killCounter = 0
+		return nil
+	}
+	if availableMem, err = availableMemory(); err != nil {
+		return err
+	}
+
+	// Killing user processes doesn't recover the memory leaked by the kernel, so the unreclaimable slab memory
+	// above its allowed fraction is left out of the deficit covered by killing
+	excess, err := slab.Excess()
+	if err != nil {
+		log.Errorf("Error getting unreclaimable slab memory: %v", err)
+	}
+	userDeficit := func(available uint64) uint64 {
+		deficit := memoryDeficit(available)
+		if deficit <= excess {
+			return 0
+		}
+		return deficit - excess
+	}
+	deficit := userDeficit(availableMem)
+	if excess > 0 {
+		log.Warningf("Unreclaimable slab memory exceeds its allowed fraction by %vMB of the deficit of %vMB, %vMB are left to recover by killing",
+			excess, memoryDeficit(availableMem), deficit)
+		utils.LogEvent(utils.KernelMemoryLeak, "slab", utils.Error)
+		if deficit == 0 {
 			killCounter = 0
+			return nil
+		}
+	}
+
+	// The victims are killed one at a time and the deficit is measured again after each kill, the rest of the
+	// victims is planned again if a kill failed or released a different amount of memory than accounted.
+	candidates := GetMemoryActivities(c)
+	for kills := 0; kills < maxKills; kills++ {
+		victims := planVictims(candidates, deficit)
+		if len(victims) == 0 {
+			if deficit > 0 {
+				log.Warningf("Memory deficit is %vMB but no activity is left to kill", deficit)
+			}
+			return nil
+		}
+		reclaim := victims.Total()
+		log.Infof("Memory deficit is %vMB, killing %v to reclaim %vMB", deficit, victims, reclaim)
+		utils.LogToKernel("ORK: memory deficit is %vMB, killing %v to reclaim %vMB\n", deficit, victims, reclaim)
+
+		activ := victims[0]
+		candidates = candidates.Without(activ)
+		if accounting, ok := activ.(Accounting); ok {
+			usage := accounting.MemoryUsage()
+			log.Debugf("Memory of %v: rss %vMB, pss %vMB, uss %vMB, swap %vMB", activ.Name(), usage.RSS, usage.PSS, usage.USS, usage.Swap)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		if memOk, err = isMemoryOk(); err != nil {
+		if err := activ.Kill(); err != nil {
+			continue
+		}
+		c.Remove(activ)
+		killCounter = 0
+
+		if availableMem, err = waitRelease(availableMemory, availableMem, activ.Memory()); err != nil {
 			return err
 		}
+		deficit = userDeficit(availableMem)
 	}
 	return nil
 }
@@ -0,0 +1,313 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/orktest"
+	"github.com/zero-os/0-ork/process"
+	"github.com/zero-os/0-ork/registry"
+)
+
+// activity is a memory consuming activity that records whether it was killed
+type activity struct {
+	orktest.Activity
+	memory uint64
+}
+
+func newActivity(name string, memory uint64) *activity {
+	return &activity{Activity: orktest.Activity{Label: name}, memory: memory}
+}
+
+func (a *activity) Memory() uint64 { return a.memory }
+
+// newFakeHost returns a host with a single NUMA node, no hugepages and no swap
+func newFakeHost(t *testing.T) *host.Fake {
+	f := orktest.NewHost(t, func() {
+		killCounter = 0
+		swapCounter = 0
+		hugePagesCounter = 0
+		exhaustedPools = make(map[string]struct{})
+		lastReclaim = time.Time{}
+		nodeCounters = make(map[int]int)
+	})
+	f.Set("/proc/sys/vm/drop_caches", "0")
+	f.Set("/proc/sys/vm/compact_memory", "0")
+	f.Set("/proc/vmstat", "pswpin 0\npswpout 0\n")
+	setAvailable(f, 2048)
+	return f
+}
+
+// startProcesses starts the processes on the fake system, the memory of a killed process is released
+func startProcesses(f *host.Fake, s *process.FakeSystem, processes ...process.FakeProcess) {
+	memory := make(map[int32]uint64)
+	for _, p := range processes {
+		s.Start(p)
+		memory[p.Pid] = p.Memory
+	}
+	s.OnExit(func(pid int32) { setAvailable(f, 50+memory[pid]) })
+}
+
+// setAvailable sets the available memory of the host in MB
+func setAvailable(f *host.Fake, available uint64) {
+	setMemory(f, available, 64)
+}
+
+// setMemory sets the available memory and the unreclaimable slab memory of the host in MB
+func setMemory(f *host.Fake, available, unreclaimable uint64) {
+	f.Set("/proc/meminfo", fmt.Sprintf("MemTotal:       16777216 kB\nMemFree:        %v kB\nMemAvailable:   %v kB\n"+
+		"SUnreclaim:        %v kB\nSwapTotal:             0 kB\nSwapFree:              0 kB\n", available*1024, available*1024, unreclaimable*1024))
+}
+
+func TestMonitorKillsSmallestSet(t *testing.T) {
+	f := newFakeHost(t)
+	s := orktest.NewSystem(t, f)
+	big := process.FakeProcess{Pid: 100, Name: "big", Memory: 400}
+	medium := process.FakeProcess{Pid: 200, Name: "medium", Memory: 260}
+	small := process.FakeProcess{Pid: 300, Name: "small", Memory: 50}
+	startProcesses(f, s, big, medium, small)
+	c := registry.New()
+	monitor := func(checks int) {
+		for i := 0; i < checks; i++ {
+			process.UpdateCache(c)
+			if err := Monitor(context.Background(), c); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	monitor(10)
+	if len(f.Written("/proc/sys/vm/drop_caches")) != 0 {
+		t.Fatal("expected no reclaim while memory is available")
+	}
+
+	// the available memory has to stay below the threshold for 5 checks
+	setAvailable(f, 50)
+	monitor(4)
+	if len(f.Written("/proc/sys/vm/drop_caches")) != 0 {
+		t.Fatal("expected no reclaim before the memory stays low")
+	}
+	monitor(1)
+
+	// reclaiming doesn't recover anything so the deficit of 250MB is covered by killing medium only
+	if written := f.Written("/proc/sys/vm/drop_caches"); !reflect.DeepEqual(written, []string{"3"}) {
+		t.Errorf("expected the caches to be dropped once, got %v", written)
+	}
+	if written := f.Written("/proc/sys/vm/compact_memory"); !reflect.DeepEqual(written, []string{"1"}) {
+		t.Errorf("expected the memory to be compacted once, got %v", written)
+	}
+	if s.Killed(big.Pid) || !s.Killed(medium.Pid) || s.Killed(small.Pid) {
+		t.Errorf("expected only medium to be killed, killed big %v medium %v small %v",
+			s.Killed(big.Pid), s.Killed(medium.Pid), s.Killed(small.Pid))
+	}
+	if orktest.Collected(c, "200") {
+		t.Error("expected the killed process to be removed")
+	}
+	if log := strings.Join(f.Written("/dev/kmsg"), ""); !strings.Contains(log, "killing [200]") {
+		t.Errorf("expected the kill to be logged to the kernel, got %q", log)
+	}
+
+	// the killed process is not collected again
+	monitor(1)
+	if orktest.Collected(c, "200") {
+		t.Error("expected the killed process not to be collected again")
+	}
+}
+
+func TestMonitorReplansAfterFailedKill(t *testing.T) {
+	f := newFakeHost(t)
+	s := orktest.NewSystem(t, f)
+	big := process.FakeProcess{Pid: 100, Name: "big", Memory: 400}
+	medium := process.FakeProcess{Pid: 200, Name: "medium", Memory: 260}
+	small := process.FakeProcess{Pid: 300, Name: "small", Memory: 50}
+	startProcesses(f, s, big, medium, small)
+	s.Protect(medium.Pid)
+	c := registry.New()
+
+	setAvailable(f, 50)
+	for i := 0; i < 5; i++ {
+		process.UpdateCache(c)
+		if err := Monitor(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !s.Killed(big.Pid) || s.Killed(small.Pid) {
+		t.Errorf("expected big to be killed when medium can't be, killed big %v small %v", s.Killed(big.Pid), s.Killed(small.Pid))
+	}
+	if !orktest.Collected(c, "200") {
+		t.Error("expected the process that couldn't be killed to be kept")
+	}
+}
+
+// numaActivity is an activity with memory on a single NUMA node
+type numaActivity struct {
+	*activity
+	node int
+}
+
+func (a *numaActivity) NodeMemory() (map[int]uint64, error) {
+	return map[int]uint64{a.node: a.memory}, nil
+}
+
+// setNodeAvailable sets the free memory of a NUMA node in MB
+func setNodeAvailable(f *host.Fake, node int, available uint64) {
+	f.Set(fmt.Sprintf("/sys/devices/system/node/node%v/meminfo", node), fmt.Sprintf("Node %v MemFree: %v kB\n", node, available*1024))
+}
+
+func TestMonitorNUMAMeasuresAfterEachKill(t *testing.T) {
+	f := newFakeHost(t)
+	setNodeAvailable(f, 0, 50)
+	setNodeAvailable(f, 1, 2048)
+	// killing big releases more memory on the node than it accounts for, which covers the whole deficit
+	big := &numaActivity{newActivity("big", 200), 0}
+	big.OnKill = func() { setNodeAvailable(f, 0, 350) }
+	small := &numaActivity{newActivity("small", 100), 0}
+	other := &numaActivity{newActivity("other", 1024), 1}
+	c := registry.New()
+	for _, activ := range []*numaActivity{big, small, other} {
+		c.Set(orktest.Kind, activ.ID(), activ)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := Monitor(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !big.Killed || small.Killed || other.Killed {
+		t.Errorf("expected only big to be killed, killed big %v small %v other %v", big.Killed, small.Killed, other.Killed)
+	}
+}
+
+func TestMonitorLeavesSlabExcessOut(t *testing.T) {
+	f := newFakeHost(t)
+	s := orktest.NewSystem(t, f)
+	big := process.FakeProcess{Pid: 100, Name: "big", Memory: 400}
+	medium := process.FakeProcess{Pid: 200, Name: "medium", Memory: 160}
+	small := process.FakeProcess{Pid: 300, Name: "small", Memory: 50}
+	startProcesses(f, s, big, medium, small)
+	// the unreclaimable slab memory exceeds its allowed fifth of the 16GB of memory by 100MB
+	unreclaimable := uint64(3276 + 100)
+	s.OnExit(func(pid int32) { setMemory(f, 210, unreclaimable) })
+	c := registry.New()
+
+	setMemory(f, 50, unreclaimable)
+	for i := 0; i < 5; i++ {
+		process.UpdateCache(c)
+		if err := Monitor(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 150MB of the deficit of 250MB are left to recover by killing
+	if s.Killed(big.Pid) || !s.Killed(medium.Pid) || s.Killed(small.Pid) {
+		t.Errorf("expected only medium to be killed, killed big %v medium %v small %v",
+			s.Killed(big.Pid), s.Killed(medium.Pid), s.Killed(small.Pid))
+	}
+}
+
+func TestPlanVictimsWithoutDeficit(t *testing.T) {
+	activities := Activities{newActivity("big", 400), newActivity("small", 50)}
+	if victims := planVictims(activities, 0); len(victims) != 0 {
+		t.Errorf("expected no victim without deficit, got %v", victims.Names())
+	}
+}
+
+func TestMonitorReclaims(t *testing.T) {
+	f := newFakeHost(t)
+	big := newActivity("big", 400)
+	c := registry.New()
+	c.Set(orktest.Kind, big.ID(), big)
+
+	setAvailable(f, 50)
+	for i := 0; i < 4; i++ {
+		if err := Monitor(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// dropping the caches recovers enough memory
+	reclaimStages[0].run = func(deficit uint64) error {
+		setAvailable(f, 1024)
+		return dropCaches(deficit)
+	}
+	defer func() { reclaimStages[0].run = dropCaches }()
+
+	if err := Monitor(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	if big.Killed {
+		t.Error("expected no activity to be killed when reclaiming recovers enough memory")
+	}
+	if written := f.Written("/proc/sys/vm/compact_memory"); len(written) != 0 {
+		t.Errorf("expected the reclaim to stop after dropping the caches, got %v", written)
+	}
+}
+
+// hugePagesActivity is an activity backed by hugepages that records whether it was killed
+type hugePagesActivity struct {
+	*activity
+	hugePages uint64
+}
+
+func (a *hugePagesActivity) HugePages() uint64 { return a.hugePages }
+
+// setHugePages sets the 2MB hugepages pool of the host
+func setHugePages(f *host.Fake, total, free, reserved, surplus uint64) {
+	dir := hugePagesPath + "/hugepages-2048kB/"
+	f.Set(dir+"nr_hugepages", fmt.Sprintf("%v\n", total))
+	f.Set(dir+"free_hugepages", fmt.Sprintf("%v\n", free))
+	f.Set(dir+"resv_hugepages", fmt.Sprintf("%v\n", reserved))
+	f.Set(dir+"surplus_hugepages", fmt.Sprintf("%v\n", surplus))
+}
+
+func TestMonitorHugePages(t *testing.T) {
+	f := newFakeHost(t)
+	orktest.SetOptions(f, "hugepageskill")
+	vm := &hugePagesActivity{activity: newActivity("vm", 0), hugePages: 4096}
+	c := registry.New()
+	c.Set(orktest.Kind, vm.ID(), vm)
+
+	// a fully allocated pool without reservations nor surplus pages is the normal state
+	setHugePages(f, 4096, 0, 0, 0)
+	for i := 0; i < 2*hugePagesSustain; i++ {
+		if err := Monitor(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vm.Killed {
+		t.Fatal("expected no domain to be destroyed while no more hugepages are demanded")
+	}
+	if log := strings.Join(f.Written("/dev/kmsg"), ""); strings.Count(log, "hugepages pool 2048kB of host is exhausted") != 1 {
+		t.Errorf("expected the exhausted pool to be reported once, got %q", log)
+	}
+
+	// a pool with a tenth of its pages free isn't exhausted even if less than hugePagesThreshold is free
+	setHugePages(f, 4096, 410, 0, 0)
+	if err := Monitor(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+
+	// the free pages are all reserved by new mappings or surplus pages are allocated beyond the pool
+	for i := 0; i < hugePagesSustain-1; i++ {
+		if i%2 == 0 {
+			setHugePages(f, 4096, 8, 8, 0)
+		} else {
+			setHugePages(f, 4096, 0, 0, 16)
+		}
+		if err := Monitor(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vm.Killed {
+		t.Fatal("expected no domain to be destroyed before the pool stays under pressure")
+	}
+	if err := Monitor(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	if !vm.Killed {
+		t.Error("expected the domain using the most hugepages to be destroyed when the pool stays under pressure")
+	}
+}
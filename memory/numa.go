@@ -0,0 +1,174 @@
+package memory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+const nodesPath = "/sys/devices/system/node"
+
+// nodeThreshold is the value in MB of available memory on a NUMA node at which ORK should free-up memory on it
+const nodeThreshold uint64 = 100
+
+type NUMA interface {
+	registry.Activity
+	NodeMemory() (map[int]uint64, error)
+	Kill() error
+	Name() string
+}
+
+// nodeActivity ranks a NUMA activity by the memory it has on a single node
+type nodeActivity struct {
+	NUMA
+	memory uint64
+}
+
+func (n nodeActivity) Memory() uint64 {
+	return n.memory
+}
+
+// GetNodeActivities returns the activities that have memory on node sorted by the memory they have on it in
+// descending order.
+func GetNodeActivities(c *registry.Registry, node int) Activities {
+	var candidates []NUMA
+	c.Range(func(value interface{}) bool {
+		if activity, ok := value.(NUMA); ok {
+			candidates = append(candidates, activity)
+		}
+		return true
+	})
+
+	activities := make(Activities, 0)
+	for _, activity := range candidates {
+		nodes, err := activity.NodeMemory()
+		if err != nil {
+			log.Debugf("Error getting numa memory of %v: %v", activity.Name(), err)
+			continue
+		}
+		if nodes[node] > 0 {
+			activities = append(activities, nodeActivity{activity, nodes[node]})
+		}
+	}
+	sort.Sort(sort.Reverse(activities))
+	return activities
+}
+
+// nodeCounters holds the number of consecutive checks each node was under pressure
+var nodeCounters = make(map[int]int)
+
+// nodesAvailableMemory returns the available memory in MB of each NUMA node.
+// The available memory of a node is estimated as its free memory plus its page cache and reclaimable slab.
+func nodesAvailableMemory() (map[int]uint64, error) {
+	paths, err := host.Glob(filepath.Join(nodesPath, "node[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[int]uint64, len(paths))
+	for _, path := range paths {
+		node, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "node"))
+		if err != nil {
+			continue
+		}
+		contents, err := host.ReadFile(filepath.Join(path, "meminfo"))
+		if err != nil {
+			return nil, err
+		}
+
+		// lines are formatted as: Node 0 MemFree:         3388936 kB
+		var available uint64
+		scanner := bufio.NewScanner(bytes.NewReader(contents))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 {
+				continue
+			}
+			switch fields[2] {
+			case "MemFree:", "Active(file):", "Inactive(file):", "SReclaimable:":
+				value, err := strconv.ParseUint(fields[3], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("Error parsing %v of node %v: %v", fields[2], node, err)
+				}
+				available += value
+			}
+		}
+		nodes[node] = available / 1024 //convert kilo byte to mega byte
+	}
+	return nodes, nil
+}
+
+// monitorNUMA checks the available memory of each NUMA node and if a node stays below nodeThreshold it kills
+// the smallest set of activities having memory on that node that recovers enough memory on it, measuring the
+// node again after each kill.
+// Nothing is done on hosts with a single node since the global memory check covers them.
+func monitorNUMA(ctx context.Context, c *registry.Registry) error {
+	nodes, err := nodesAvailableMemory()
+	if err != nil {
+		log.Errorf("Error getting numa nodes memory: %v", err)
+		return err
+	}
+	if len(nodes) < 2 {
+		return nil
+	}
+
+	for node, available := range nodes {
+		if available > nodeThreshold {
+			nodeCounters[node] = 0
+			continue
+		}
+		nodeCounters[node] += 1
+		log.Debugf("Memory available on node %v is lower than threshold: %v and kill counter is %v", node, available, nodeCounters[node])
+		if nodeCounters[node] < 5 {
+			continue
+		}
+
+		// the victims are killed one at a time and the available memory of the node is measured again after each
+		// kill like for the global memory
+		measure := func() (uint64, error) { return nodeAvailableMemory(node) }
+		candidates := GetNodeActivities(c, node)
+		for kills := 0; kills < maxKills; kills++ {
+			deficit := memoryDeficit(available)
+			victims := planVictims(candidates, deficit)
+			if len(victims) == 0 {
+				break
+			}
+			log.Infof("Memory deficit on node %v is %vMB, killing %v to reclaim %vMB", node, deficit, victims, victims.Total())
+			utils.LogToKernel("ORK: memory deficit on node %v is %vMB, killing %v to reclaim %vMB\n", node, deficit, victims, victims.Total())
+
+			activ := victims[0]
+			candidates = candidates.Without(activ)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := activ.Kill(); err != nil {
+				continue
+			}
+			c.Remove(activ)
+			nodeCounters[node] = 0
+
+			if available, err = waitRelease(measure, available, activ.Memory()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nodeAvailableMemory returns the available memory in MB of node
+func nodeAvailableMemory(node int) (uint64, error) {
+	nodes, err := nodesAvailableMemory()
+	if err != nil {
+		return 0, err
+	}
+	return nodes[node], nil
+}
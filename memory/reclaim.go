@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// reclaimCooldown is the minimum time between two reclaim attempts, dropping caches
+// too often hurts the performance of the host more than it helps.
+const reclaimCooldown = time.Minute
+
+const cgroupReclaimPath = "/sys/fs/cgroup/memory.reclaim"
+
+var lastReclaim time.Time
+
+// reclaimStage is a non destructive action that asks the kernel to free memory
+type reclaimStage struct {
+	name string
+	run  func(deficit uint64) error
+}
+
+var reclaimStages = []reclaimStage{
+	{"drop_caches", dropCaches},
+	{"compact_memory", compactMemory},
+	{"cgroup_reclaim", cgroupReclaim},
+}
+
+// dropCaches frees the clean page cache and the reclaimable slab objects
+func dropCaches(deficit uint64) error {
+	return host.WriteFile("/proc/sys/vm/drop_caches", []byte("3"))
+}
+
+// compactMemory defragments memory so that higher order allocations can succeed
+func compactMemory(deficit uint64) error {
+	return host.WriteFile("/proc/sys/vm/compact_memory", []byte("1"))
+}
+
+// cgroupReclaim asks the kernel to reclaim deficit MB from the root cgroup, it is only supported by
+// cgroup v2 on recent kernels.
+func cgroupReclaim(deficit uint64) error {
+	if !host.Exists(cgroupReclaimPath) {
+		log.Debugf("%v is not supported", cgroupReclaimPath)
+		return nil
+	}
+	return host.WriteFile(cgroupReclaimPath, []byte(fmt.Sprintf("%vM", deficit)))
+}
+
+// reclaim runs the reclaim stages until the available memory reaches recoveryTarget and returns true if it did.
+// The memory recovered by each stage is logged and each stage is recorded as an event.
+func reclaim(deficit uint64) (bool, error) {
+	if time.Since(lastReclaim) < reclaimCooldown {
+		return false, nil
+	}
+	lastReclaim = time.Now()
+
+	for _, stage := range reclaimStages {
+		before, err := availableMemory()
+		if err != nil {
+			return false, err
+		}
+
+		if err := stage.run(deficit); err != nil {
+			log.Errorf("Error running reclaim stage %v: %v", stage.name, err)
+			utils.LogEvent(utils.MemoryReclaim, stage.name, utils.Error)
+			continue
+		}
+
+		after, err := availableMemory()
+		if err != nil {
+			return false, err
+		}
+		var recovered uint64
+		if after > before {
+			recovered = after - before
+		}
+		log.Infof("Reclaim stage %v recovered %vMB, available memory is %vMB", stage.name, recovered, after)
+		utils.LogToKernel("ORK: reclaim stage %v recovered %vMB\n", stage.name, recovered)
+		utils.LogEvent(utils.MemoryReclaim, stage.name, utils.Success)
+
+		if after >= recoveryTarget {
+			return true, nil
+		}
+		deficit = recoveryTarget - after
+	}
+	return false, nil
+}
@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// swapInThreshold is the rate in MB per second of memory swapped in at which the host is considered thrashing
+const swapInThreshold float64 = 20
+
+// swapOutThreshold is the rate in MB per second of memory swapped out at which the host is considered thrashing
+// when the free swap is below swapFreeThreshold
+const swapOutThreshold float64 = 20
+
+// swapFreeThreshold is the value in MB of free swap under which heavy swapping out is considered an emergency
+const swapFreeThreshold uint64 = 100
+
+// swapSustain is the number of consecutive checks the host has to be thrashing before ORK takes action
+const swapSustain = 10
+
+type Swap interface {
+	registry.Activity
+	Swap() utils.SwapUsage
+	Kill() error
+	Name() string
+}
+
+type SwapActivities []Swap
+
+func (a SwapActivities) Len() int { return len(a) }
+
+func (a SwapActivities) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+
+func (a SwapActivities) Less(i, j int) bool {
+	si, sj := a[i].Swap(), a[j].Swap()
+	if si.Faults != sj.Faults {
+		return si.Faults < sj.Faults
+	}
+	return si.Used < sj.Used
+}
+
+// GetSwapActivities returns the activities that have swapped out memory sorted by their swap activity
+// in descending order.
+func GetSwapActivities(c *registry.Registry) SwapActivities {
+	activities := make(SwapActivities, 0)
+
+	c.Range(func(value interface{}) bool {
+		if activity, ok := value.(Swap); ok && activity.Swap().Used > 0 {
+			activities = append(activities, activity)
+		}
+		return true
+	})
+	sort.Sort(sort.Reverse(activities))
+	return activities
+}
+
+var swapIn = utils.NewAverage(5*time.Second, 10)
+var swapOut = utils.NewAverage(5*time.Second, 10)
+var swapInRate = utils.NewRate(64)
+var swapOutRate = utils.NewRate(64)
+var swapCounter = 0
+
+// isSwapOk returns false if the host has been swapping in heavily, or swapping out heavily while
+// running out of swap, for swapSustain consecutive checks.
+func isSwapOk() (bool, error) {
+	v, err := host.ReadSwap()
+	if err != nil {
+		log.Error("Error getting swap memory")
+		return false, err
+	}
+	if v.Total == 0 {
+		return true, nil
+	}
+
+	// In and Out are the bytes swapped in and out since boot as reported by pswpin and pswpout in /proc/vmstat
+	now := time.Now()
+	in, inOk := swapInRate.Add(now, v.In)
+	out, outOk := swapOutRate.Add(now, v.Out)
+	if !inOk || !outOk {
+		return true, nil
+	}
+	swapIn.Add(now, in/(1024*1024))
+	swapOut.Add(now, out/(1024*1024))
+	swapFree := v.Free / (1024 * 1024)
+
+	if swapIn.Value() < swapInThreshold &&
+		(swapFree > swapFreeThreshold || swapOut.Value() < swapOutThreshold) {
+		swapCounter = 0
+		log.Debugf("Swap activity is below threshold: in %vMB/s, out %vMB/s, free %vMB", swapIn.Value(), swapOut.Value(), swapFree)
+		return true, nil
+	}
+	swapCounter += 1
+
+	log.Debugf("Swap activity is above threshold: in %vMB/s, out %vMB/s, free %vMB and swap counter is %v", swapIn.Value(), swapOut.Value(), swapFree, swapCounter)
+	return swapCounter < swapSustain, nil
+}
+
+// monitorSwap kills the activity responsible for the most swap activity if the host is thrashing
+func monitorSwap(ctx context.Context, c *registry.Registry) error {
+	swapOk, err := isSwapOk()
+	if err != nil || swapOk {
+		return err
+	}
+
+	activities := GetSwapActivities(c)
+	if len(activities) == 0 {
+		log.Warning("Host is thrashing but no activity is using swap")
+		return nil
+	}
+
+	activ := activities[0]
+	usage := activ.Swap()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	log.Infof("Host is thrashing, killing %v using %vMB of swap with %.2f major faults per second", activ.Name(), usage.Used, usage.Faults)
+	if err := activ.Kill(); err != nil {
+		utils.LogEvent(utils.SwapThrashing, activ.Name(), utils.Error)
+		return err
+	}
+	utils.LogEvent(utils.SwapThrashing, activ.Name(), utils.Success)
+	c.Remove(activ)
+	swapCounter = 0
+	return nil
+}
@@ -0,0 +1,102 @@
+// Package monitor defines the interface implemented by the ORK monitors and registers the monitors ORK starts
+package monitor
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+var log = logging.MustGetLogger("ORK")
+
+// Monitor is a check that is run periodically against the state collected by ORK
+type Monitor interface {
+	Name() string
+	Interval() time.Duration
+	Run(ctx context.Context, state *registry.Registry) error
+}
+
+type monitorFunc struct {
+	name     string
+	interval time.Duration
+	deadline time.Duration
+	run      func(context.Context, *registry.Registry) error
+}
+
+func (m *monitorFunc) Name() string {
+	return m.name
+}
+
+func (m *monitorFunc) Interval() time.Duration {
+	return m.interval
+}
+
+func (m *monitorFunc) Deadline() time.Duration {
+	if m.deadline == 0 {
+		return deadline
+	}
+	return m.deadline
+}
+
+func (m *monitorFunc) Run(ctx context.Context, state *registry.Registry) error {
+	return m.run(ctx, state)
+}
+
+// New returns a Monitor called name that calls run every interval
+func New(name string, interval time.Duration, run func(context.Context, *registry.Registry) error) Monitor {
+	return &monitorFunc{
+		name:     name,
+		interval: interval,
+		run:      run,
+	}
+}
+
+// NewWithDeadline returns a Monitor called name that calls run every interval and is only considered stalled
+// by the watchdog if a run doesn't complete within deadline
+func NewWithDeadline(name string, interval time.Duration, deadline time.Duration, run func(context.Context, *registry.Registry) error) Monitor {
+	return &monitorFunc{
+		name:     name,
+		interval: interval,
+		deadline: deadline,
+		run:      run,
+	}
+}
+
+var lock sync.Mutex
+var registered = map[string]Monitor{}
+
+// Register adds m to the monitors started by ORK, it is meant to be called from the init function of the
+// package implementing the monitor.
+func Register(m Monitor) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, ok := registered[m.Name()]; ok {
+		panic("monitor " + m.Name() + " is registered twice")
+	}
+	registered[m.Name()] = m
+}
+
+// Monitors returns the registered monitors that are not disabled in the kernel parameters sorted by name
+func Monitors() []Monitor {
+	lock.Lock()
+	defer lock.Unlock()
+
+	var monitors []Monitor
+	for name, m := range registered {
+		if !utils.MonitorEnabled(name) {
+			log.Infof("Monitor %v is disabled", name)
+			continue
+		}
+		monitors = append(monitors, m)
+	}
+	sort.Slice(monitors, func(i, j int) bool {
+		return monitors[i].Name() < monitors[j].Name()
+	})
+	return monitors
+}
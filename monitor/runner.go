@@ -0,0 +1,94 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+	"github.com/zero-os/0-ork/watchdog"
+)
+
+// deadline is the time after which a monitor that didn't complete a run is considered stalled by the watchdog
+const deadline = time.Minute
+
+// maxBackoff is the longest a monitor waits before running again after a panic, it has to stay below deadline
+const maxBackoff = 30 * time.Second
+
+// Deadliner is implemented by the monitors whose runs may take longer than the default deadline
+type Deadliner interface {
+	Deadline() time.Duration
+}
+
+// Start runs all the enabled monitors under the watchdog until ctx is done
+func Start(ctx context.Context, w *watchdog.Watchdog, state *registry.Registry) {
+	for _, m := range Monitors() {
+		Go(ctx, w, m, state)
+	}
+}
+
+// Go runs m under the watchdog until ctx is done
+func Go(ctx context.Context, w *watchdog.Watchdog, m Monitor, state *registry.Registry) {
+	interval := utils.MonitorInterval(m.Name(), m.Interval())
+	log.Infof("Starting monitor %v every %v", m.Name(), interval)
+
+	limit := deadline
+	if d, ok := m.(Deadliner); ok {
+		limit = d.Deadline()
+	}
+	w.Go(ctx, m.Name(), limit, func(ctx context.Context, beat watchdog.Beat) {
+		loop(ctx, m, interval, state, beat)
+	})
+}
+
+// loop runs m every interval until ctx is done. After a panic the wait is doubled up to maxBackoff until a run
+// completes.
+func loop(ctx context.Context, m Monitor, interval time.Duration, state *registry.Registry, beat watchdog.Beat) {
+	utils.LockRealtime()
+
+	wait := interval
+	for beat() {
+		if err := run(ctx, m, state); err == nil {
+			wait = interval
+		} else if _, ok := err.(panicError); ok {
+			log.Error(err)
+			wait *= 2
+			if wait > maxBackoff {
+				wait = maxBackoff
+			}
+		} else {
+			log.Error(err)
+			wait = interval
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Infof("Stopping monitor %v", m.Name())
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+type panicError struct {
+	name  string
+	value interface{}
+	stack []byte
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprintf("Monitor %v panicked: %v\n%s", e.name, e.value, e.stack)
+}
+
+// run runs m once and turns a panic into a panicError
+func run(ctx context.Context, m Monitor, state *registry.Registry) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			utils.LogToKernel("ORK: monitor %v panicked\n", m.Name())
+			err = panicError{name: m.Name(), value: value, stack: debug.Stack()}
+		}
+	}()
+	return m.Run(ctx, state)
+}
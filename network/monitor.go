@@ -1,8 +1,12 @@
 package network
 
 import (
+	"context"
+	"time"
+
 	"github.com/op/go-logging"
-	"github.com/patrickmn/go-cache"
+	"github.com/zero-os/0-ork/monitor"
+	"github.com/zero-os/0-ork/registry"
 )
 
 var log = logging.MustGetLogger("ORK")
@@ -10,9 +14,13 @@ var log = logging.MustGetLogger("ORK")
 const byteThreshold float64 = 175000000.0 // 70% of 2Gbit in bytes
 const packetThreshold float64 = 28000.0   // 70% of 40kpps
 
+func init() {
+	monitor.Register(monitor.New("network", time.Second, Monitor))
+}
+
 // Monitor checks the network consumption per interface and if the rate is higher than the threshold, it shutsdown the
-// interface exceeding the networkThreshhold
-func Monitor(c *cache.Cache) error {
+// interface exceeding the networkThreshhold. Nothing is shut down once ctx is done.
+func Monitor(ctx context.Context, c *registry.Registry) error {
 	log.Debug("Monitoring network")
 
 	activities := GetNetworkActivities(c)
@@ -21,6 +29,9 @@ func Monitor(c *cache.Cache) error {
 		netUsage := activ.Network()
 		if netUsage.Txb >= byteThreshold ||
 			netUsage.Txp >= packetThreshold {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			activ.Kill()
 		}
 
@@ -0,0 +1,73 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/nic"
+	"github.com/zero-os/0-ork/orktest"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// activity is an activity with a fixed network usage that records whether it was killed
+type activity struct {
+	orktest.Activity
+	usage utils.NetworkUsage
+}
+
+func (a *activity) Network() utils.NetworkUsage { return a.usage }
+
+// setStatistics sets the counters of an interface of the fake host
+func setStatistics(f *host.Fake, iface string, txb, txp uint64) {
+	f.Set(fmt.Sprintf("/sys/class/net/%v/statistics/rx_bytes", iface), "0\n")
+	f.Set(fmt.Sprintf("/sys/class/net/%v/statistics/rx_packets", iface), "0\n")
+	f.Set(fmt.Sprintf("/sys/class/net/%v/statistics/tx_bytes", iface), fmt.Sprintf("%v\n", txb))
+	f.Set(fmt.Sprintf("/sys/class/net/%v/statistics/tx_packets", iface), fmt.Sprintf("%v\n", txp))
+}
+
+func TestCollectAndMonitor(t *testing.T) {
+	f := orktest.NewHost(t, nil)
+	f.Set("/sys/class/net/orkvx0/uevent", "DEVTYPE=vxlan\nINTERFACE=orkvx0\n")
+	f.Set("/sys/class/net/orketh0/uevent", "INTERFACE=orketh0\n")
+	setStatistics(f, "orketh0", 0, 0)
+
+	c := registry.New()
+	var txb, txp uint64
+	for i := 0; i < 15; i++ {
+		txb += 1500
+		txp++
+		setStatistics(f, "orkvx0", txb, txp)
+		nic.UpdateCache(c)
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, ok := c.Get(nic.Kind, "orketh0"); ok {
+		t.Error("expected only the vxlan interfaces to be collected")
+	}
+	value, ok := c.Get(nic.Kind, "orkvx0")
+	if !ok {
+		t.Fatal("expected the vxlan interface to be collected")
+	}
+	usage := value.(Network).Network()
+	if usage.Txb <= 0 || usage.Txp <= 0 || usage.Rxb != 0 || usage.Rxp != 0 {
+		t.Errorf("expected only transmit rates, got %+v", usage)
+	}
+	if usage.Txb >= byteThreshold || usage.Txp >= packetThreshold {
+		t.Fatalf("expected the collected rates to be below the thresholds, got %+v", usage)
+	}
+
+	flooding := &activity{Activity: orktest.Activity{Label: "flooding"}, usage: utils.NetworkUsage{Txp: packetThreshold}}
+	quiet := &activity{Activity: orktest.Activity{Label: "quiet"}, usage: utils.NetworkUsage{Txb: byteThreshold / 2}}
+	c.Set(orktest.Kind, flooding.ID(), flooding)
+	c.Set(orktest.Kind, quiet.ID(), quiet)
+	if err := Monitor(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	if !flooding.Killed || quiet.Killed {
+		t.Errorf("expected only the flooding activity to be killed, killed flooding %v quiet %v", flooding.Killed, quiet.Killed)
+	}
+}
@@ -1,26 +1,27 @@
 package network
 
 import (
-	"github.com/patrickmn/go-cache"
+	"github.com/zero-os/0-ork/registry"
 	"github.com/zero-os/0-ork/utils"
 )
 
 type Network interface {
+	registry.Activity
 	Network() utils.NetworkUsage
 	Kill() error
 	Name() string
 }
 
-func GetNetworkActivities(c *cache.Cache) []Network {
-	items := c.Items()
-	activities := make([]Network, 0, c.ItemCount())
+func GetNetworkActivities(c *registry.Registry) []Network {
+	activities := make([]Network, 0, c.Len())
 
-	for _, item := range items {
-		activity, ok := item.Object.(Network)
+	c.Range(func(value interface{}) bool {
+		activity, ok := value.(Network)
 		if !ok {
-			continue
+			return true
 		}
 		activities = append(activities, activity)
-	}
+		return true
+	})
 	return activities
 }
@@ -6,14 +6,13 @@ import (
 	"github.com/zero-os/0-ork/utils"
 
 	"fmt"
-	"io/ioutil"
 	"strconv"
 	"strings"
 
-	"github.com/VividCortex/ewma"
 	"github.com/op/go-logging"
-	"github.com/patrickmn/go-cache"
 	"github.com/vishvananda/netlink"
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/registry"
 )
 
 const byteThreshold float64 = 225000000.0 // 90% of 2Gbit in bytes
@@ -24,26 +23,40 @@ const tbfLimit = 3000
 
 var log = logging.MustGetLogger("ORK")
 
+// Kind is the registry kind of the nics
+var Kind = registry.Kind{Name: "nic", Expiry: time.Minute}
+
 type ifStat struct {
 	rxb, txb, rxp, txp uint64
 }
 
-type ifaceDelta struct {
-	rxb, txb, rxp, txp func(uint64) uint64
+type ifaceRate struct {
+	rxb, txb, rxp, txp *utils.Rate
 }
 
 type ifaceEwma struct {
-	rxb, txb, rxp, txp ewma.MovingAverage
+	rxb, txb, rxp, txp *utils.Average
 }
 
+// Nic is a snapshot of a nic published by UpdateCache, it is never modified once it is in the registry
 type Nic struct {
 	name     string
 	netUsage utils.NetworkUsage
-	delta    ifaceDelta
-	ewma     ifaceEwma
-	rate     int
 }
 
+// tracker holds the history of a nic used to compute its snapshots
+type tracker struct {
+	rate ifaceRate
+	ewma ifaceEwma
+}
+
+// trackers holds the tracker of each nic, it is only accessed by UpdateCache
+var trackers = make(map[string]*tracker)
+
+// squeezes holds the number of times each nic was squeezed. It is owned by the network monitor which is
+// the only caller of Kill.
+var squeezes = make(map[string]int)
+
 type rate struct {
 	bw    uint64
 	delay uint32
@@ -92,6 +105,14 @@ func (n *Nic) Name() string {
 	return n.name
 }
 
+func (n *Nic) Kind() registry.Kind {
+	return Kind
+}
+
+func (n *Nic) ID() string {
+	return n.name
+}
+
 func (n *Nic) setDown() error {
 	link, err := netlink.LinkByName(n.name)
 	if err != nil {
@@ -115,7 +136,7 @@ func (n *Nic) setDown() error {
 	return nil
 }
 
-func (n *Nic) applyTbf(link netlink.Link, parent uint32) error {
+func (n *Nic) applyTbf(link netlink.Link, parent uint32, r rate) error {
 	//squeezing: tc qdisc add dev $NIC parent 1:1 handle 10: tbf rate 1mbit buffer 1600 limit 3000
 	qdiskAttrs := netlink.QdiscAttrs{
 		LinkIndex: link.Attrs().Index,
@@ -123,50 +144,68 @@ func (n *Nic) applyTbf(link netlink.Link, parent uint32) error {
 	}
 	qdisc := netlink.Tbf{
 		QdiscAttrs: qdiskAttrs,
-		Rate:       rates[n.rate].bw,
+		Rate:       r.bw,
 		Buffer:     tbfBuffer,
 		Limit:      tbfLimit,
 	}
 
-	utils.LogToKernel("ORK: limiting bandwith of interface %v to %v\n", n.name, rates[n.rate].bw)
-	log.Debugf("Limiting bandwith of interface %v to %v", n.name, rates[n.rate].bw)
+	utils.LogToKernel("ORK: limiting bandwith of interface %v to %v\n", n.name, r.bw)
+	log.Debugf("Limiting bandwith of interface %v to %v", n.name, r.bw)
 
 	err := netlink.QdiscAdd(&qdisc)
 	if err != nil {
-		utils.LogToKernel("ORK: error limiting bandwith of interface %v to %v\n", n.name, rates[n.rate].bw)
-		log.Errorf("Error limiting bandwith of interface %v to %v: %v", n.name, rates[n.rate].bw, err)
+		utils.LogToKernel("ORK: error limiting bandwith of interface %v to %v\n", n.name, r.bw)
+		log.Errorf("Error limiting bandwith of interface %v to %v: %v", n.name, r.bw, err)
 		return err
 	}
 	return nil
 }
 
-func (n *Nic) applyNetem(link netlink.Link, parent uint32) error {
+func (n *Nic) applyNetem(link netlink.Link, parent uint32, r rate) error {
 	//latency: tc qdisc add dev $NIC root handle 1:0 netem delay 200ms
 	qdiscAttrs := netlink.QdiscAttrs{
 		LinkIndex: link.Attrs().Index,
 		Parent:    parent,
 	}
 	netemAttrs := netlink.NetemQdiscAttrs{
-		Latency: rates[n.rate].delay,
+		Latency: r.delay,
 	}
 	qdisc := netlink.NewNetem(qdiscAttrs, netemAttrs)
 
-	utils.LogToKernel("ORK: adding latency %v to interface %v\n", rates[n.rate].delay, n.name)
-	log.Debugf("Adding latency %v to interface %v", rates[n.rate].delay, n.name)
+	utils.LogToKernel("ORK: adding latency %v to interface %v\n", r.delay, n.name)
+	log.Debugf("Adding latency %v to interface %v", r.delay, n.name)
 
 	err := netlink.QdiscAdd(qdisc)
 	if err != nil {
-		utils.LogToKernel("ORK: error adding latency %v to interface %v\n", rates[n.rate].delay, n.name)
-		log.Errorf("Error adding latency %v to interface %v: %v", rates[n.rate].delay, n.name, err)
+		utils.LogToKernel("ORK: error adding latency %v to interface %v\n", r.delay, n.name)
+		log.Errorf("Error adding latency %v to interface %v: %v", r.delay, n.name, err)
 		return err
 	}
 
 	return nil
 }
 
+// forgetSqueezes forgets the squeezes of the nics that are gone
+func forgetSqueezes() {
+	ifaces, err := listNics()
+	if err != nil {
+		return
+	}
+	present := make(map[string]struct{}, len(ifaces))
+	for _, iface := range ifaces {
+		present[iface] = struct{}{}
+	}
+	for name := range squeezes {
+		if _, ok := present[name]; !ok {
+			delete(squeezes, name)
+		}
+	}
+}
+
 func (n *Nic) squeeze() error {
-	n.rate++
-	newRate, ok := rates[n.rate]
+	forgetSqueezes()
+	squeezes[n.name]++
+	newRate, ok := rates[1+squeezes[n.name]]
 	// Nic reached maximum rate and needs to be setdown
 	if !ok {
 		return n.setDown()
@@ -203,7 +242,7 @@ func (n *Nic) squeeze() error {
 	parent := uint32(netlink.HANDLE_ROOT)
 
 	if newRate.bw > 0 {
-		err := n.applyTbf(link, parent)
+		err := n.applyTbf(link, parent, newRate)
 		if err == nil {
 			handle, err := getQdiscHandle(link, "tbf", parent)
 			if err == nil {
@@ -212,7 +251,7 @@ func (n *Nic) squeeze() error {
 		}
 	}
 	if newRate.delay > 0 {
-		err := n.applyNetem(link, parent)
+		err := n.applyNetem(link, parent, newRate)
 		if err != nil {
 			return err
 		}
@@ -230,78 +269,90 @@ func (n *Nic) Kill() error {
 	return n.squeeze()
 }
 
-func UpdateCache(c *cache.Cache) {
+// add adds the rate per second of counter to average unless it is the first value of the counter or it was reset
+func add(now time.Time, average *utils.Average, counter *utils.Rate, value uint64) {
+	if rate, ok := counter.Add(now, value); ok {
+		average.Add(now, rate)
+	}
+}
+
+func UpdateCache(c *registry.Registry) {
 	ifaces, err := listNics()
 	if err != nil {
 		log.Errorf("Error listing nics: %v", err)
 		return
 	}
+	seen := make(map[string]bool, len(ifaces))
 	for _, iface := range ifaces {
+		seen[iface] = true
 		stats, err := readStatistics(iface)
 		if err != nil {
 			log.Errorf("Error reading nic stats: %v", err)
 			continue
 		}
-		n, ok := c.Get(iface)
-		// If the nic doesn't exist in the cache, create a new instance for it
+		t, ok := trackers[iface]
+		// If the nic is not tracked yet, create a new tracker for it
 		if !ok {
-			nic := &Nic{}
-			nic.name = iface
-			nic.delta.rxb = utils.Delta(stats.rxb)
-			nic.delta.txb = utils.Delta(stats.txb)
-			nic.delta.rxp = utils.Delta(stats.rxp)
-			nic.delta.txp = utils.Delta(stats.txp)
-			nic.ewma.rxb = ewma.NewMovingAverage(180)
-			nic.ewma.txb = ewma.NewMovingAverage(180)
-			nic.ewma.rxp = ewma.NewMovingAverage(180)
-			nic.ewma.txp = ewma.NewMovingAverage(180)
-			nic.netUsage.Rxb = nic.ewma.rxb.Value()
-			nic.netUsage.Txb = nic.ewma.txb.Value()
-			nic.netUsage.Rxp = nic.ewma.rxp.Value()
-			nic.netUsage.Txp = nic.ewma.txp.Value()
-			nic.rate = 1
-
-			c.Set(iface, nic, time.Minute)
-			continue
+			t = &tracker{}
+			t.rate.rxb = utils.NewRate(64)
+			t.rate.txb = utils.NewRate(64)
+			t.rate.rxp = utils.NewRate(64)
+			t.rate.txp = utils.NewRate(64)
+			t.ewma.rxb = utils.NewAverage(180*time.Second, 10)
+			t.ewma.txb = utils.NewAverage(180*time.Second, 10)
+			t.ewma.rxp = utils.NewAverage(180*time.Second, 10)
+			t.ewma.txp = utils.NewAverage(180*time.Second, 10)
+			trackers[iface] = t
 		}
+		// Add the new statistics to emwa and calculate the new usage percentage.
+		// The first statistics of a nic and the statistics after a reset of its counters are discarded.
+		now := time.Now()
+		add(now, t.ewma.rxb, t.rate.rxb, stats.rxb)
+		add(now, t.ewma.txb, t.rate.txb, stats.txb)
+		add(now, t.ewma.rxp, t.rate.rxp, stats.rxp)
+		add(now, t.ewma.txp, t.rate.txp, stats.txp)
+
+		nic := &Nic{name: iface}
+		nic.netUsage.Rxb = t.ewma.rxb.Value()
+		nic.netUsage.Txb = t.ewma.txb.Value()
+		nic.netUsage.Rxp = t.ewma.rxp.Value()
+		nic.netUsage.Txp = t.ewma.txp.Value()
+		c.Set(Kind, iface, nic)
+	}
 
-		// If the nic exists in the cache, add the new statistics to emwa and calculate the new usage percentage.
-		nic := n.(*Nic)
-		nic.ewma.rxb.Add(float64(nic.delta.rxb(stats.rxb)))
-		nic.ewma.txb.Add(float64(nic.delta.txb(stats.txb)))
-		nic.ewma.rxp.Add(float64(nic.delta.rxp(stats.rxp)))
-		nic.ewma.txp.Add(float64(nic.delta.txp(stats.txp)))
-		nic.netUsage.Rxb = nic.ewma.rxb.Value()
-		nic.netUsage.Txb = nic.ewma.txb.Value()
-		nic.netUsage.Rxp = nic.ewma.rxp.Value()
-		nic.netUsage.Txp = nic.ewma.txp.Value()
-
-		c.Set(iface, nic, time.Minute)
+	for iface := range trackers {
+		if !seen[iface] {
+			delete(trackers, iface)
+		}
 	}
 }
 
+// listNics returns the vxlan interfaces of the host, the type of an interface is the DEVTYPE of its uevent
 func listNics() ([]string, error) {
 	var ifaces []string
-	l, err := ioutil.ReadDir("/sys/class/net")
+	l, err := host.ReadDir("/sys/class/net")
 	if err != nil {
 		log.Errorf("Error reading dir /sys/class/net: %v", err)
 		return nil, err
 	}
 	for _, iface := range l {
-		link, err := netlink.LinkByName(iface.Name())
+		uevent, err := host.ReadFile(fmt.Sprintf("/sys/class/net/%v/uevent", iface))
 		if err != nil {
-			log.Errorf("Error getting link for %v: %v", iface.Name(), err)
-			return nil, err
+			log.Errorf("Error getting type of %v: %v", iface, err)
+			continue
 		}
-		if link.Type() == "vxlan" {
-			ifaces = append(ifaces, iface.Name())
+		for _, line := range strings.Split(string(uevent), "\n") {
+			if line == "DEVTYPE=vxlan" {
+				ifaces = append(ifaces, iface)
+				break
+			}
 		}
 	}
 	return ifaces, nil
 }
 
 func readVal(path string) (uint64, error) {
-	contents, err := ioutil.ReadFile(path)
+	contents, err := host.ReadFile(path)
 	if err != nil {
 		log.Errorf("Error reading file %v:%v", path, err)
 		return 0, err
@@ -0,0 +1,63 @@
+package nic_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/zero-os/0-ork/network"
+	"github.com/zero-os/0-ork/nic"
+	"github.com/zero-os/0-ork/orktest"
+	"github.com/zero-os/0-ork/registry"
+)
+
+// TestConcurrentCollectionAndAction runs the collection of the nics while the network monitor and squeezes
+// act on their snapshots, it is meant to be run with -race
+func TestConcurrentCollectionAndAction(t *testing.T) {
+	f := orktest.NewHost(t, nil)
+	f.Set("/sys/class/net/orkvx0/uevent", "DEVTYPE=vxlan\nINTERFACE=orkvx0\n")
+	for _, counter := range []string{"rx_bytes", "rx_packets", "tx_bytes", "tx_packets"} {
+		f.Set(fmt.Sprintf("/sys/class/net/orkvx0/statistics/%v", counter), "0\n")
+	}
+
+	r := registry.New()
+	nic.UpdateCache(r)
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	// collector
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := uint64(1); ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			f.Set("/sys/class/net/orkvx0/statistics/tx_bytes", fmt.Sprintf("%v\n", i*1500))
+			nic.UpdateCache(r)
+		}
+	}()
+
+	// monitor acting on the nics, the nic doesn't exist on the host so the squeezes fail once counted
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			if err := network.Monitor(context.Background(), r); err != nil {
+				t.Error(err)
+			}
+			if value, ok := r.Get(nic.Kind, "orkvx0"); ok {
+				value.(*nic.Nic).Kill()
+			}
+		}
+	}()
+	wg.Wait()
+
+	if _, ok := r.Get(nic.Kind, "orkvx0"); !ok {
+		t.Error("expected the nic to be collected")
+	}
+}
@@ -0,0 +1,88 @@
+// Package orktest provides the fake host and the activities shared by the tests of the monitors
+package orktest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/process"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// Kind is the kind of the activities built by tests
+var Kind = registry.Kind{Name: "test", Expiry: time.Minute}
+
+// Activity is an activity built by a test that records whether it was killed, the tests of the monitors
+// embed it in activities implementing the interfaces of their monitor
+type Activity struct {
+	Label      string
+	Killed     bool
+	Unkillable bool   // Kill fails
+	OnKill     func() // called when the activity is killed
+}
+
+func (a *Activity) Name() string        { return a.Label }
+func (a *Activity) Kind() registry.Kind { return Kind }
+func (a *Activity) ID() string          { return a.Label }
+
+func (a *Activity) Kill() error {
+	if a.Unkillable {
+		return fmt.Errorf("%v can't be killed", a.Label)
+	}
+	a.Killed = true
+	if a.OnKill != nil {
+		a.OnKill()
+	}
+	return nil
+}
+
+// NewHost returns a fake host without ork kernel parameters and makes ORK use it. When the test ends the
+// host and the options used before are restored and reset is called to reset the state of the monitor.
+func NewHost(tb testing.TB, reset func()) *host.Fake {
+	f := host.NewFake()
+	f.Set("/dev/kmsg", "")
+	previous := host.Use(f)
+	SetOptions(f)
+	tb.Cleanup(func() {
+		host.Use(previous)
+		utils.LoadOptions()
+		if reset != nil {
+			reset()
+		}
+	})
+	return f
+}
+
+// SetOptions sets the ork kernel parameters of the fake host and reloads them
+func SetOptions(f *host.Fake, options ...string) {
+	cmdline := "console=ttyS0"
+	if len(options) != 0 {
+		cmdline += " ork=" + strings.Join(options, " ork=")
+	}
+	f.Set("/proc/cmdline", cmdline)
+	utils.LoadOptions()
+}
+
+// NewSystem returns a fake system whose processes live in the /proc of the fake host f and makes ORK act
+// on its processes instead of the processes of the host until the test ends
+func NewSystem(tb testing.TB, f *host.Fake) *process.FakeSystem {
+	s := process.NewFakeSystem(f)
+	previous := process.UseSystem(s)
+	tb.Cleanup(func() { process.UseSystem(previous) })
+	return s
+}
+
+// Collected returns true if c holds an activity with the name
+func Collected(c *registry.Registry, name string) bool {
+	var found bool
+	c.Range(func(value interface{}) bool {
+		activ, ok := value.(interface{ Name() string })
+		found = ok && activ.Name() == name
+		return !found
+	})
+	return found
+}
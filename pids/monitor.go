@@ -0,0 +1,195 @@
+// Package pids implements a monitor of the number of tasks to stop fork bombs before the pids run out
+package pids
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/monitor"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// tasksThreshold is the fraction of the maximum number of tasks at which ORK should kill the tasks spawner
+const tasksThreshold float64 = 0.9
+
+// groupThreshold is the fraction of the maximum number of tasks a single uid or cgroup may use
+const groupThreshold float64 = 0.5
+
+// spawnThreshold is the rate of processes created per second under a process above which it is considered
+// spawning processes at an abnormal rate
+const spawnThreshold float64 = 50
+
+// subtreeFactor is the fraction of the highest spawn rate a descendant of the top spawner needs to have to be
+// considered the origin of the spawning instead of it
+const subtreeFactor float64 = 0.9
+
+var log = logging.MustGetLogger("ORK")
+
+func readInt(path string) (uint64, error) {
+	contents, err := host.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(contents)), 10, 64)
+}
+
+// tasksLimit returns the maximum number of tasks the host can run which is the lowest of pid_max and threads-max
+func tasksLimit() (uint64, error) {
+	pidMax, err := readInt("/proc/sys/kernel/pid_max")
+	if err != nil {
+		return 0, err
+	}
+	threadsMax, err := readInt("/proc/sys/kernel/threads-max")
+	if err != nil {
+		return 0, err
+	}
+	if threadsMax < pidMax {
+		return threadsMax, nil
+	}
+	return pidMax, nil
+}
+
+// totalTasks returns the number of tasks running on the host as reported by /proc/loadavg
+func totalTasks() (uint64, error) {
+	contents, err := host.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	// the fourth field is formatted as: running/total
+	fields := strings.Fields(string(contents))
+	if len(fields) < 4 {
+		return 0, fmt.Errorf("Invalid /proc/loadavg: %v", string(contents))
+	}
+	tasks := strings.SplitN(fields[3], "/", 2)
+	if len(tasks) != 2 {
+		return 0, fmt.Errorf("Invalid /proc/loadavg: %v", string(contents))
+	}
+	return strconv.ParseUint(tasks[1], 10, 64)
+}
+
+// findSpawner returns the root of the smallest subtree spawning processes at an abnormal rate among activities.
+// Births are accounted for all the ancestors of a process, so the spawner is the activity with the lowest rate
+// among the ones close to the highest rate.
+func findSpawner(activities []Tasks) Tasks {
+	var highest float64
+	for _, activ := range activities {
+		if rate := activ.SpawnRate(); rate > highest {
+			highest = rate
+		}
+	}
+	if highest < spawnThreshold {
+		return nil
+	}
+
+	var spawner Tasks
+	for _, activ := range activities {
+		rate := activ.SpawnRate()
+		if rate < highest*subtreeFactor {
+			continue
+		}
+		if spawner == nil || rate < spawner.SpawnRate() {
+			spawner = activ
+		}
+	}
+	return spawner
+}
+
+func init() {
+	monitor.Register(monitor.New("pids", time.Second, Monitor))
+}
+
+// Monitor checks the number of tasks of the host, of each uid and of each cgroup against the maximum number of
+// tasks and if one of them exceeds its threshold it kills the process tree spawning processes at an abnormal rate.
+// The tasks of the uids and cgroups are counted from the processes of the latest scan.
+// Nothing is killed once ctx is done.
+func Monitor(ctx context.Context, c *registry.Registry) error {
+	log.Debug("Monitoring pids")
+
+	limit, err := tasksLimit()
+	if err != nil {
+		log.Errorf("Error getting maximum number of tasks: %v", err)
+		return err
+	}
+	total, err := totalTasks()
+	if err != nil {
+		log.Errorf("Error getting number of tasks: %v", err)
+		return err
+	}
+
+	activities := latestScan(GetTasksActivities(c))
+	uids := make(map[uint32]uint64)
+	cgroups := make(map[string]uint64)
+	for _, activ := range activities {
+		uids[activ.UID()] += activ.Threads()
+		cgroups[activ.Cgroup()] += activ.Threads()
+	}
+
+	// Restrict the search of the spawner to the uids and cgroups exceeding their threshold
+	exceeded := total >= uint64(float64(limit)*tasksThreshold)
+	groupLimit := uint64(float64(limit) * groupThreshold)
+	offendingUIDs := make(map[uint32]struct{})
+	for uid, count := range uids {
+		if count < groupLimit {
+			continue
+		}
+		log.Warningf("Uid %v is running %v tasks out of %v", uid, count, limit)
+		offendingUIDs[uid] = struct{}{}
+	}
+	offendingCgroups := make(map[string]struct{})
+	for cgroup, count := range cgroups {
+		if count < groupLimit {
+			continue
+		}
+		log.Warningf("Cgroup %v is running %v tasks out of %v", cgroup, count, limit)
+		offendingCgroups[cgroup] = struct{}{}
+	}
+	candidates := activities
+	if len(offendingUIDs) != 0 || len(offendingCgroups) != 0 {
+		exceeded = true
+		candidates = filter(activities, func(activ Tasks) bool {
+			_, uid := offendingUIDs[activ.UID()]
+			_, cgroup := offendingCgroups[activ.Cgroup()]
+			return uid || cgroup
+		})
+	}
+
+	if !exceeded {
+		log.Debugf("Number of tasks is below threshold: %v out of %v", total, limit)
+		return nil
+	}
+	log.Warningf("Number of tasks is above threshold: %v out of %v", total, limit)
+
+	spawner := findSpawner(candidates)
+	if spawner == nil {
+		log.Warning("No process is spawning processes at an abnormal rate")
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	log.Infof("Killing process tree of %v spawning %.2f processes per second", spawner.Name(), spawner.SpawnRate())
+	if err := spawner.KillTree(); err != nil {
+		utils.LogEvent(utils.ForkBomb, spawner.Name(), utils.Error)
+		return err
+	}
+	utils.LogEvent(utils.ForkBomb, spawner.Name(), utils.Success)
+	c.Remove(spawner)
+	return nil
+}
+
+func filter(activities []Tasks, keep func(Tasks) bool) []Tasks {
+	filtered := make([]Tasks, 0)
+	for _, activ := range activities {
+		if keep(activ) {
+			filtered = append(filtered, activ)
+		}
+	}
+	return filtered
+}
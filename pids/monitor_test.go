@@ -0,0 +1,75 @@
+package pids
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/orktest"
+	"github.com/zero-os/0-ork/process"
+	"github.com/zero-os/0-ork/registry"
+)
+
+// newFakeHost returns a host running 100 tasks out of a maximum of 1000
+func newFakeHost(t *testing.T) (*host.Fake, *process.FakeSystem) {
+	f := orktest.NewHost(t, nil)
+	f.Set("/proc/sys/kernel/pid_max", "1000\n")
+	f.Set("/proc/sys/kernel/threads-max", "1000\n")
+	f.Set("/proc/loadavg", "0.00 0.00 0.00 1/100 1234\n")
+	return f, orktest.NewSystem(t, f)
+}
+
+// spawn starts a child of bomb with threads threads in its own cgroup, collects the processes and runs the monitor
+func spawn(t *testing.T, s *process.FakeSystem, c *registry.Registry, bomb process.FakeProcess, pid int32, threads int) {
+	cgroup := fmt.Sprintf("%v/%v", bomb.Cgroup, pid)
+	s.Start(process.FakeProcess{Pid: pid, PPid: bomb.Pid, Name: "child", UID: bomb.UID, Cgroup: cgroup, Threads: threads})
+	process.UpdateCache(c)
+	if err := Monitor(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMonitorKillsSpawnerOfAnyOffendingGroup(t *testing.T) {
+	_, s := newFakeHost(t)
+	// the uid of the bomb and the cgroup of the pool run more than half of the tasks but only the bomb spawns processes
+	pool := process.FakeProcess{Pid: 100, Name: "pool", UID: 2000, Cgroup: "/pool", Threads: 600}
+	bomb := process.FakeProcess{Pid: 200, Name: "bomb", UID: 1000, Cgroup: "/bomb"}
+	s.Start(pool)
+	s.Start(bomb)
+	c := registry.New()
+	process.UpdateCache(c)
+
+	for pid := int32(201); pid <= 210 && !s.Killed(bomb.Pid); pid++ {
+		spawn(t, s, c, bomb, pid, 100)
+	}
+	if !s.Killed(bomb.Pid) || !s.Killed(201) {
+		t.Error("expected the process tree of the bomb to be killed")
+	}
+	if s.Killed(pool.Pid) {
+		t.Error("expected the process of the other offending group not to be killed")
+	}
+}
+
+func TestMonitorCountsLatestScan(t *testing.T) {
+	_, s := newFakeHost(t)
+	// gone ran most of the tasks of the uid and the cgroup of the bomb but exited, it stays in the registry until it expires.
+	// The pids differ from the other tests since the births are only counted for new pids.
+	gone := process.FakeProcess{Pid: 400, Name: "gone", UID: 1000, Cgroup: "/bomb", Threads: 600}
+	bomb := process.FakeProcess{Pid: 300, Name: "bomb", UID: 1000, Cgroup: "/bomb"}
+	s.Start(gone)
+	s.Start(bomb)
+	c := registry.New()
+	process.UpdateCache(c)
+	s.Exit(gone.Pid)
+
+	for pid := int32(301); pid <= 305; pid++ {
+		spawn(t, s, c, bomb, pid, 1)
+	}
+	if !orktest.Collected(c, "400") {
+		t.Fatal("expected the exited process to still be in the registry")
+	}
+	if s.Killed(bomb.Pid) {
+		t.Error("expected the tasks of the exited process not to be counted")
+	}
+}
@@ -0,0 +1,44 @@
+package pids
+
+import (
+	"time"
+
+	"github.com/zero-os/0-ork/registry"
+)
+
+type Tasks interface {
+	registry.Activity
+	Threads() uint64
+	UID() uint32
+	Cgroup() string
+	SpawnRate() float64
+	Scanned() time.Time
+	KillTree() error
+	Name() string
+}
+
+func GetTasksActivities(c *registry.Registry) []Tasks {
+	activities := make([]Tasks, 0, c.Len())
+
+	c.Range(func(value interface{}) bool {
+		activity, ok := value.(Tasks)
+		if !ok {
+			return true
+		}
+		activities = append(activities, activity)
+		return true
+	})
+	return activities
+}
+
+// latestScan returns the activities of the latest scan, the activities of older scans exited since and
+// stay in the registry until they expire
+func latestScan(activities []Tasks) []Tasks {
+	var latest time.Time
+	for _, activ := range activities {
+		if activ.Scanned().After(latest) {
+			latest = activ.Scanned()
+		}
+	}
+	return filter(activities, func(activ Tasks) bool { return activ.Scanned().Equal(latest) })
+}
@@ -0,0 +1,236 @@
+package process
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/zero-os/0-ork/host"
+)
+
+// FakeProcess is a process of a FakeSystem
+type FakeProcess struct {
+	Pid     int32
+	PPid    int32 // core0 if it is not set
+	Name    string
+	UID     uint32
+	Cgroup  string
+	Threads int
+	Memory  uint64 // resident and private memory in MB
+	CPU     uint64 // consumed cpu time in clock ticks
+	Policy  int    // scheduling policy of its threads
+}
+
+// fakeStartTime is the start time of the last fake process, it only grows so that the fake processes
+// of different tests never share an identity
+var fakeStartTime uint64
+
+// FakeSystem is an in-memory System for tests. Its processes descend from core0 and are written to the
+// /proc of a fake host so that they are collected by UpdateCache. A killed process is removed from /proc.
+type FakeSystem struct {
+	lock      sync.Mutex
+	host      *host.Fake
+	processes map[int32]*fakeProcess
+	killed    map[int32]bool
+	exited    func(pid int32)
+	nice      map[int]int
+	ioClass   map[int]int
+	ioLevel   map[int]int
+	policy    map[int]int
+}
+
+type fakeProcess struct {
+	FakeProcess
+	startTime uint64
+	protected bool
+}
+
+// NewFakeSystem returns a FakeSystem running only core0 on the fake host f
+func NewFakeSystem(f *host.Fake) *FakeSystem {
+	s := &FakeSystem{
+		host:      f,
+		processes: make(map[int32]*fakeProcess),
+		killed:    make(map[int32]bool),
+		nice:      make(map[int]int),
+		ioClass:   make(map[int]int),
+		ioLevel:   make(map[int]int),
+		policy:    make(map[int]int),
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.start(FakeProcess{Pid: 1, Name: "core0"})
+	return s
+}
+
+// Start starts a fake process
+func (s *FakeSystem) Start(p FakeProcess) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.start(p)
+}
+
+func (s *FakeSystem) start(p FakeProcess) {
+	if p.Threads == 0 {
+		p.Threads = 1
+	}
+	if p.Cgroup == "" {
+		p.Cgroup = "/"
+	}
+	s.processes[p.Pid] = &fakeProcess{FakeProcess: p, startTime: atomic.AddUint64(&fakeStartTime, 1)}
+	delete(s.killed, p.Pid)
+	for tid := int(p.Pid); tid < int(p.Pid)+p.Threads; tid++ {
+		s.policy[tid] = p.Policy
+	}
+	s.write(p.Pid)
+}
+
+// Run adds ticks clock ticks to the cpu time of a process
+func (s *FakeSystem) Run(pid int32, ticks uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if p, ok := s.processes[pid]; ok {
+		p.CPU += ticks
+		s.write(pid)
+	}
+}
+
+// RunFor adds d to the cpu time of a process
+func (s *FakeSystem) RunFor(pid int32, d time.Duration) {
+	s.Run(pid, uint64(d)*clockTicks/uint64(time.Second))
+}
+
+// SetMemory sets the memory of a process in MB
+func (s *FakeSystem) SetMemory(pid int32, memory uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if p, ok := s.processes[pid]; ok {
+		p.Memory = memory
+		s.write(pid)
+	}
+}
+
+// Protect makes signals sent to a process fail as if ORK wasn't permitted to send them
+func (s *FakeSystem) Protect(pid int32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if p, ok := s.processes[pid]; ok {
+		p.protected = true
+	}
+}
+
+// OnExit sets a function called with the pid of each killed process
+func (s *FakeSystem) OnExit(exited func(pid int32)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.exited = exited
+}
+
+// Exit makes a process exit by itself
+func (s *FakeSystem) Exit(pid int32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.processes, pid)
+	s.host.Remove(fmt.Sprintf("/proc/%v", pid))
+}
+
+// Policy returns the scheduling policy of a thread
+func (s *FakeSystem) Policy(tid int) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.policy[tid]
+}
+
+// Killed returns true if the process was killed
+func (s *FakeSystem) Killed(pid int32) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.killed[pid]
+}
+
+// write writes the files of a process to /proc, its threads have the ids following its pid
+func (s *FakeSystem) write(pid int32) {
+	p := s.processes[pid]
+	ppid := p.PPid
+	if ppid == 0 && pid != 1 {
+		ppid = 1
+	}
+	stat := fmt.Sprintf("%v (%v) S %v %v %v 0 -1 4194560 0 0 0 0 %v 0 0 0 20 0 %v 0 %v "+
+		"184320000 0 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 0 0 %v 0 0 0 0 0 0 0 0 0 0 0\n",
+		pid, p.Name, ppid, pid, pid, p.CPU, p.Threads, p.startTime, s.policy[int(pid)])
+	kb := p.Memory * 1024
+	dir := fmt.Sprintf("/proc/%v/", pid)
+	s.host.Set(dir+"stat", stat)
+	s.host.Set(dir+"statm", fmt.Sprintf("0 %v 0 0 0 0 0\n", kb*1024/pageSize))
+	s.host.Set(dir+"status", fmt.Sprintf("Name:\t%v\nUid:\t%v\t%v\t%v\t%v\n", p.Name, p.UID, p.UID, p.UID, p.UID))
+	s.host.Set(dir+"cgroup", fmt.Sprintf("0::%v\n", p.Cgroup))
+	s.host.Set(dir+"smaps_rollup", fmt.Sprintf("Rss: %v kB\nPss: %v kB\nPrivate_Clean: 0 kB\nPrivate_Dirty: %v kB\n"+
+		"Swap: 0 kB\nSwapPss: 0 kB\n", kb, kb, kb))
+	for tid := int(pid); tid < int(pid)+p.Threads; tid++ {
+		s.host.Set(fmt.Sprintf("%vtask/%v/stat", dir, tid), stat)
+	}
+}
+
+func (s *FakeSystem) Signal(pid int32, startTime uint64, sig syscall.Signal) error {
+	s.lock.Lock()
+	p, ok := s.processes[pid]
+	if !ok || p.startTime != startTime {
+		s.lock.Unlock()
+		return syscall.ESRCH
+	}
+	if p.protected {
+		s.lock.Unlock()
+		return syscall.EPERM
+	}
+	if sig != syscall.SIGKILL {
+		s.lock.Unlock()
+		return nil
+	}
+	delete(s.processes, pid)
+	s.killed[pid] = true
+	s.host.Remove(fmt.Sprintf("/proc/%v", pid))
+	exited := s.exited
+	s.lock.Unlock()
+
+	if exited != nil {
+		exited(pid)
+	}
+	return nil
+}
+
+func (s *FakeSystem) Nice(tid int) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.nice[tid], nil
+}
+
+func (s *FakeSystem) SetNice(tid int, nice int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.nice[tid] = nice
+	return nil
+}
+
+func (s *FakeSystem) IoPriority(tid int) (int, int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ioClass[tid], s.ioLevel[tid], nil
+}
+
+func (s *FakeSystem) SetIoPriority(tid int, class int, level int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ioClass[tid], s.ioLevel[tid] = class, level
+	return nil
+}
+
+func (s *FakeSystem) SetScheduler(tid int, policy int, priority int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.policy[tid] = policy
+	if _, ok := s.processes[int32(tid)]; ok {
+		s.write(int32(tid))
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package process
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// identity identifies a process, the start time tells apart the processes that reused the same pid
+type identity struct {
+	pid       int32
+	startTime uint64
+}
+
+func (id identity) String() string {
+	return fmt.Sprintf("%v:%v", id.pid, id.startTime)
+}
+
+// verify returns an error if the process with the pid of id is not the process id refers to
+func (id identity) verify() error {
+	stat, err := readStat(id.pid)
+	if err != nil {
+		return err
+	}
+	if stat.startTime != id.startTime {
+		return fmt.Errorf("Process %v was replaced by a new process with the same pid", id.pid)
+	}
+	return nil
+}
+
+// signal sends sig to the process id refers to, the signal never reaches a new process that reused the pid
+func (id identity) signal(sig syscall.Signal) error {
+	return system.Signal(id.pid, id.startTime, sig)
+}
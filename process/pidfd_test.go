@@ -0,0 +1,36 @@
+package process
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestSignalVerifiesIdentity(t *testing.T) {
+	child := exec.Command("sleep", "60")
+	if err := child.Start(); err != nil {
+		t.Skipf("failed to start a child process: %v", err)
+	}
+	defer child.Process.Kill()
+
+	pid := int32(child.Process.Pid)
+	stat, err := readStat(pid)
+	if err != nil {
+		t.Fatalf("failed to read the stat of the child process: %v", err)
+	}
+
+	reused := identity{pid, stat.startTime + 1}
+	if err := reused.signal(syscall.SIGKILL); err == nil {
+		t.Fatal("expected a process with another start time not to be signaled")
+	}
+	if err := syscall.Kill(int(pid), 0); err != nil {
+		t.Fatalf("expected the child process to be alive: %v", err)
+	}
+
+	if err := (identity{pid, stat.startTime}).signal(syscall.SIGKILL); err != nil {
+		t.Fatalf("failed to kill the child process: %v", err)
+	}
+	if err := child.Wait(); err == nil {
+		t.Fatal("expected the child process to be killed")
+	}
+}
@@ -0,0 +1,191 @@
+package process
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// priority holds the cpu and I/O priorities of a process
+type priority struct {
+	nice    map[int]int // niceness of each thread
+	ioClass int
+	ioLevel int
+}
+
+// tids returns the ids of the threads of the process, scheduling priorities are set per thread.
+// It fails if the pid was reused by another process.
+func (p *Process) tids() ([]int, error) {
+	if err := p.id.verify(); err != nil {
+		log.Errorf("Error verifying process %v: %v", p.id.pid, err)
+		return nil, err
+	}
+	tasks, err := host.ReadDir(fmt.Sprintf("/proc/%v/task", p.id.pid))
+	if err != nil {
+		log.Errorf("Error listing threads of process %v: %v", p.id.pid, err)
+		return nil, err
+	}
+
+	tids := make([]int, 0, len(tasks))
+	for _, task := range tasks {
+		if tid, err := strconv.Atoi(task); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids, nil
+}
+
+// originals holds the priorities of the processes before ORK changed them. It is owned by the cpu monitor
+// which is the only caller of Renice, IoNice and RestorePriority.
+var originals = make(map[identity]*priority)
+
+// saveOriginal keeps the priorities of the process before ORK changes them so that they can be restored
+func (p *Process) saveOriginal() error {
+	if _, ok := originals[p.id]; ok {
+		return nil
+	}
+	tids, err := p.tids()
+	if err != nil {
+		return err
+	}
+	nice := make(map[int]int, len(tids))
+	for _, tid := range tids {
+		n, err := system.Nice(tid)
+		if err != nil {
+			// the thread exited
+			continue
+		}
+		nice[tid] = n
+	}
+	class, level, err := system.IoPriority(int(p.id.pid))
+	if err != nil {
+		log.Errorf("Error getting I/O priority of process %v: %v", p.id.pid, err)
+		return err
+	}
+	originals[p.id] = &priority{nice, class, level}
+	return nil
+}
+
+// Realtime returns true if the process runs with a realtime scheduling policy
+func (p *Process) Realtime() bool {
+	return p.policy == utils.SchedFIFO || p.policy == utils.SchedRR
+}
+
+// Demote moves all the threads of the process to the normal scheduling policy
+func (p *Process) Demote() error {
+	pid := p.id.pid
+	tids, err := p.tids()
+	if err != nil {
+		return err
+	}
+
+	utils.LogToKernel("ORK: attempting to demote realtime process with pid %v and priority %v\n", pid, p.rtPriority)
+	for _, tid := range tids {
+		if err := system.SetScheduler(tid, utils.SchedOther, 0); err != nil {
+			utils.LogToKernel("ORK: error demoting realtime process with pid %v\n", pid)
+			log.Errorf("Error demoting thread %v of process %v: %v", tid, pid, err)
+			return err
+		}
+	}
+
+	utils.LogToKernel("ORK: successfully demoted realtime process with pid %v\n", pid)
+	log.Infof("Successfully demoted realtime process %v", pid)
+	return nil
+}
+
+// Renice sets the niceness of all the threads of the process
+func (p *Process) Renice(nice int) error {
+	if err := p.saveOriginal(); err != nil {
+		return err
+	}
+	return p.setNice(func(tid int) int { return nice })
+}
+
+// IoNice sets the I/O scheduling class and level of all the threads of the process
+func (p *Process) IoNice(class int, level int) error {
+	if err := p.saveOriginal(); err != nil {
+		return err
+	}
+	return p.setIoPriority(class, level)
+}
+
+// RestorePriority restores the niceness of each thread and the I/O priority the process had before it was
+// reniced. The threads started since then get the niceness the main thread had. The original priorities are
+// kept until they are restored so that a failed restore can be retried, unless the process is gone.
+func (p *Process) RestorePriority() error {
+	original, ok := originals[p.id]
+	if !ok {
+		return nil
+	}
+
+	fallback, ok := original.nice[int(p.id.pid)]
+	if !ok {
+		fallback = p.nice
+	}
+	restore := func(tid int) int {
+		if nice, ok := original.nice[tid]; ok {
+			return nice
+		}
+		return fallback
+	}
+	if err := p.setNice(restore); err != nil {
+		p.forgetGone()
+		return err
+	}
+	if err := p.setIoPriority(original.ioClass, original.ioLevel); err != nil {
+		p.forgetGone()
+		return err
+	}
+	delete(originals, p.id)
+	return nil
+}
+
+// forgetGone forgets the original priorities of the process if it is gone, there is nothing left to restore then
+func (p *Process) forgetGone() {
+	if err := p.id.verify(); err != nil {
+		delete(originals, p.id)
+	}
+}
+
+// setNice sets the niceness of each thread of the process to the niceness returned by nice for its tid
+func (p *Process) setNice(nice func(tid int) int) error {
+	pid := p.id.pid
+	tids, err := p.tids()
+	if err != nil {
+		return err
+	}
+
+	utils.LogToKernel("ORK: attempting to renice process with pid %v to %v\n", pid, nice(int(pid)))
+	for _, tid := range tids {
+		if err := system.SetNice(tid, nice(tid)); err != nil {
+			utils.LogToKernel("ORK: error renicing process with pid %v to %v\n", pid, nice(tid))
+			log.Errorf("Error renicing thread %v of process %v: %v", tid, pid, err)
+			return err
+		}
+	}
+
+	log.Infof("Successfully reniced process %v to %v", pid, nice(int(pid)))
+	return nil
+}
+
+func (p *Process) setIoPriority(class int, level int) error {
+	pid := p.id.pid
+	tids, err := p.tids()
+	if err != nil {
+		return err
+	}
+
+	utils.LogToKernel("ORK: attempting to set I/O class of process with pid %v to %v\n", pid, class)
+	for _, tid := range tids {
+		if err := system.SetIoPriority(tid, class, level); err != nil {
+			utils.LogToKernel("ORK: error setting I/O class of process with pid %v to %v\n", pid, class)
+			log.Errorf("Error setting I/O class of thread %v of process %v: %v", tid, pid, err)
+			return err
+		}
+	}
+
+	log.Infof("Successfully set I/O class of process %v to %v", pid, class)
+	return nil
+}
@@ -2,17 +2,34 @@ package process
 
 import (
 	"fmt"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/VividCortex/ewma"
 	"github.com/op/go-logging"
-	"github.com/patrickmn/go-cache"
-	"github.com/shirou/gopsutil/process"
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/registry"
 	"github.com/zero-os/0-ork/utils"
 )
 
 var log = logging.MustGetLogger("ORK")
 
+// Kind is the registry kind of the processes, they are scanned every second so a process that is not seen
+// for a few seconds is gone
+var Kind = registry.Kind{Name: "process", Expiry: 10 * time.Second}
+
+// memTrendInterval and memTrendWindow define how the memory consumption of processes is tracked over time
+const memTrendInterval = 10 * time.Second
+const memTrendWindow = 5 * time.Minute
+
+// smapsInterval is the interval at which the memory accounting of a process is read from smaps_rollup. Reading it
+// walks all the mappings of the process under its mmap lock, so in between only the RSS read from statm is updated.
+const smapsInterval = 10 * time.Second
+
+// smapsChange is the change of RSS in MB since the last read of smaps_rollup after which it is read again
+// before smapsInterval elapsed
+const smapsChange uint64 = 64
+
 // whiteListNames is slice of processes names that should never be killed.
 var whitelistNames = map[string]struct{}{
 	"0-ork":              struct{}{},
@@ -28,28 +45,194 @@ var killableKidsNames = map[string]struct{}{
 	"coreX": struct{}{},
 }
 
-type processesMap map[int32]*process.Process
+type processesMap map[int32]procStat
 type whiteListMap map[int32]struct{}
 type killableKidsPids map[int32]struct{}
 
-// Processes is a struct of a list of process.Process and a function to be
-// used to sort the list.
+// Process is a snapshot of a process published by UpdateCache. A snapshot is never modified once it is
+// in the registry, the next scan publishes a new one.
 type Process struct {
-	process  *process.Process
-	memUsage uint64
-	cpuTime  ewma.MovingAverage
-	cpuDelta func(uint64) uint64
-	name     string
+	id         identity
+	memUsage   utils.MemoryUsage
+	memGrowth  utils.Growth
+	faults     float64
+	cpuTime    float64
+	cpuRecent  float64
+	spawnRate  float64
+	threads    uint64
+	uid        uint32
+	cgroup     string
+	blocked    time.Time
+	policy     int
+	rtPriority int
+	nice       int
+	command    string
+	name       string
+	scanned    time.Time
+}
+
+// tracker holds the history of a process used to compute its snapshots
+type tracker struct {
+	memTrend   *utils.Trend
+	faults     *utils.Average
+	faultsRate *utils.Rate
+	cpuTime    *utils.Average
+	cpuRate    *utils.Rate
+	cpuRecent  float64 // cpu time per second since the previous scan
+	spawnRate  *utils.Average
+	uid        uint32
+	cgroup     string
+	blocked    time.Time
+	smaps      utils.MemoryUsage // memory accounting as of the last read of smaps_rollup
+	smapsAt    time.Time
 }
 
+// trackers holds the tracker of each process, it is only accessed by UpdateCache. The trackers are keyed by
+// pid and start time so that a process reusing a pid doesn't inherit the history of the previous one.
+var trackers = make(map[identity]*tracker)
+
+// procScanner reads the processes for UpdateCache, it is only accessed by UpdateCache
+var procScanner = newScanner()
+
+// lastScan is the time of the previous scan, the births counted in a scan happened since then
+var lastScan time.Time
+
 func (p *Process) CPU() float64 {
-	return p.cpuTime.Value()
+	return p.cpuTime
+}
+
+// RecentCPU returns the cpu time per second the process consumed since the previous scan
+func (p *Process) RecentCPU() float64 {
+	return p.cpuRecent
 }
 
+// Memory returns the memory consumption of the process in MB according to the configured memory metric.
+// Swapped out memory is accounted for PSS and USS, proportionally to the processes sharing it for PSS.
 func (p *Process) Memory() uint64 {
+	switch utils.MemoryMetric() {
+	case utils.RSS:
+		return p.memUsage.RSS
+	case utils.USS:
+		return p.memUsage.USS + p.memUsage.Swap
+	default:
+		return p.memUsage.PSS + p.memUsage.SwapPSS
+	}
+}
+
+// MemoryGrowth returns how the memory consumption of the process evolved over the last minutes
+func (p *Process) MemoryGrowth() utils.Growth {
+	return p.memGrowth
+}
+
+// Swap returns the swap usage of the process and its rate of major page faults
+func (p *Process) Swap() utils.SwapUsage {
+	return utils.SwapUsage{
+		Used:   p.memUsage.Swap,
+		Faults: p.faults,
+	}
+}
+
+// NodeMemory returns the memory in MB the process has on each NUMA node
+func (p *Process) NodeMemory() (map[int]uint64, error) {
+	return utils.NodeMemory(p.id.pid)
+}
+
+// MemoryUsage returns the detailed memory accounting of the process
+func (p *Process) MemoryUsage() utils.MemoryUsage {
 	return p.memUsage
 }
 
+// SpawnRate returns the rate per second at which processes are created under the process
+func (p *Process) SpawnRate() float64 {
+	return p.spawnRate
+}
+
+// Scanned returns the time of the scan the snapshot was taken in, the processes that exited since are
+// only in older scans
+func (p *Process) Scanned() time.Time {
+	return p.scanned
+}
+
+// Threads returns the number of threads of the process
+func (p *Process) Threads() uint64 {
+	return p.threads
+}
+
+// UID returns the uid owning the process
+func (p *Process) UID() uint32 {
+	return p.uid
+}
+
+// Cgroup returns the cgroup of the process
+func (p *Process) Cgroup() string {
+	return p.cgroup
+}
+
+// Blocked returns for how long the process has been in uninterruptible sleep, it is zero if the process
+// is not blocked.
+func (p *Process) Blocked() time.Duration {
+	if p.blocked.IsZero() {
+		return 0
+	}
+	return time.Since(p.blocked)
+}
+
+// WaitChannel returns the kernel function the process is sleeping in
+func (p *Process) WaitChannel() string {
+	contents, err := host.ReadFile(fmt.Sprintf("/proc/%v/wchan", p.id.pid))
+	if err != nil {
+		return ""
+	}
+	return string(contents)
+}
+
+// pseudoFilesystems are the mount points of the files that don't tell the resource a process is blocked on
+var pseudoFilesystems = []string{"/proc", "/sys", "/dev"}
+
+// Mount returns the mount point most of the files used by the process are on, the files are its open files and
+// its working directory. It is empty if the process uses no file outside of pipes, sockets and pseudo filesystems.
+func (p *Process) Mount() string {
+	mounts, err := host.MountPoints()
+	if err != nil {
+		return ""
+	}
+	dir := fmt.Sprintf("/proc/%v", p.id.pid)
+	var paths []string
+	if cwd, err := host.Readlink(dir + "/cwd"); err == nil {
+		paths = append(paths, cwd)
+	}
+	fds, _ := host.ReadDir(dir + "/fd")
+	for _, fd := range fds {
+		if target, err := host.Readlink(dir + "/fd/" + fd); err == nil {
+			paths = append(paths, target)
+		}
+	}
+
+	// ties go to the most specific mount point since every process has files on the root filesystem
+	counts := make(map[string]int)
+	var mount string
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "/") || host.MountOf(pseudoFilesystems, path) != "" {
+			continue
+		}
+		m := host.MountOf(mounts, path)
+		counts[m]++
+		if counts[m] > counts[mount] || counts[m] == counts[mount] && len(m) > len(mount) {
+			mount = m
+		}
+	}
+	return mount
+}
+
+// FD returns the number of file descriptors opened by the process
+func (p *Process) FD() (uint64, error) {
+	names, err := host.ReadDir(fmt.Sprintf("/proc/%v/fd", p.id.pid))
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(names)), nil
+}
+
 func (p *Process) Priority() int {
 	return 10
 }
@@ -58,19 +241,22 @@ func (p *Process) Name() string {
 	return p.name
 }
 
-func (p *Process) Kill() error {
-	proc := p.process
-	pid := proc.Pid
+func (p *Process) Kind() registry.Kind {
+	return Kind
+}
 
-	name, err := proc.Name()
-	if err != nil {
-		log.Error("Error getting process name")
-		name = "unknown"
-	}
+// ID returns the key of the process in the registry made of its pid and start time
+func (p *Process) ID() string {
+	return p.id.String()
+}
+
+func (p *Process) Kill() error {
+	pid := p.id.pid
+	name := p.command
 
 	utils.LogToKernel("ORK: attempting to kill process with pid %v and name %v\n", pid, name)
 
-	if err = proc.Kill(); err != nil {
+	if err := p.id.signal(syscall.SIGKILL); err != nil {
 		utils.LogToKernel("ORK: error killing process with pid %v and name %v\n", pid, name)
 		log.Errorf("Error killing process %v %v", pid, name)
 		return err
@@ -80,95 +266,143 @@ func (p *Process) Kill() error {
 	log.Infof("Successfully killed process %v %v", pid, name)
 	return nil
 }
-func UpdateCache(c *cache.Cache) {
-	pMap, err := makeProcessesMap()
+func UpdateCache(c *registry.Registry) {
+	pMap, err := procScanner.scan()
 	if err != nil {
 		log.Errorf("Error getting processes: %v", err)
+		return
 	}
 
 	whiteList, killableKids := setupWhiteList(pMap)
+	births := countBirths(pMap)
+	scan := time.Now()
+	elapsed := scan.Sub(lastScan).Seconds()
+	lastScan = scan
+	seen := make(map[identity]struct{}, len(pMap))
 
-	for pid, proc := range pMap {
-		if killable, err := isProcessKillable(proc, pMap, whiteList, killableKids); err != nil {
+	for pid, stat := range pMap {
+		if killable, err := isProcessKillable(pid, pMap, whiteList, killableKids); err != nil {
 			log.Errorf("Error checking if process is killable: %v", err)
 			continue
 		} else if killable == false {
 			continue
 		}
 
-		times, err := proc.Times()
-		if err != nil {
-			log.Errorf("Error getting process cpu percentage: %v", err)
-			continue
+		id := identity{pid, stat.startTime}
+		seen[id] = struct{}{}
+		t, tracked := trackers[id]
+		if !tracked {
+			uid, err := readUID(pid)
+			if err != nil {
+				if !vanished(err) {
+					log.Errorf("Error getting process uid: %v", err)
+				}
+				continue
+			}
+			cgroup, err := readCgroup(pid)
+			if err != nil {
+				if !vanished(err) {
+					log.Errorf("Error getting process cgroup: %v", err)
+				}
+				continue
+			}
+
+			t = &tracker{
+				cpuRate:    utils.NewRate(64),
+				cpuTime:    utils.NewAverage(60*time.Second, 10),
+				memTrend:   utils.NewTrend(memTrendInterval, memTrendWindow),
+				faults:     utils.NewAverage(10*time.Second, 10),
+				faultsRate: utils.NewRate(64),
+				spawnRate:  utils.NewAverage(30*time.Second, 0),
+				uid:        uid,
+				cgroup:     cgroup,
+			}
+			trackers[id] = t
+		}
+		// The first sample of a process only primes its counters
+		now := time.Now()
+		memory := t.memoryUsage(pid, stat, now)
+		if rate, ok := t.cpuRate.Add(now, stat.cpuTime()); ok {
+			t.cpuTime.Add(now, rate)
+			t.cpuRecent = rate
+		}
+		if rate, ok := t.faultsRate.Add(now, stat.majorFaults); ok {
+			t.faults.Add(now, rate)
+		}
+		if tracked && elapsed > 0 {
+			t.spawnRate.Add(now, float64(births[pid])/elapsed)
+		}
+		if stat.state != 'D' {
+			t.blocked = time.Time{}
+		} else if t.blocked.IsZero() {
+			t.blocked = now
 		}
-		total := times.Total()
-		nanoSeconds := time.Duration(total) * time.Second / time.Nanosecond
 
-		memory, err := proc.MemoryInfo()
-		if err != nil {
-			log.Errorf("Error getting process memory info: %v", err)
-			continue
+		snapshot := &Process{
+			name:       fmt.Sprint(pid),
+			id:         id,
+			memUsage:   memory,
+			faults:     t.faults.Value(),
+			cpuTime:    t.cpuTime.Value(),
+			cpuRecent:  t.cpuRecent,
+			spawnRate:  t.spawnRate.Value(),
+			threads:    stat.threads,
+			uid:        t.uid,
+			cgroup:     t.cgroup,
+			blocked:    t.blocked,
+			policy:     stat.policy,
+			rtPriority: stat.rtPriority,
+			nice:       stat.nice,
+			command:    stat.name,
+			scanned:    scan,
 		}
-		var cachedProcess *Process
-		key := fmt.Sprint(pid)
-		p, ok := c.Get(key)
-		if ok {
-			cachedProcess = p.(*Process)
-			cachedProcess.cpuTime.Add(float64(cachedProcess.cpuDelta(uint64(nanoSeconds))))
-		} else {
-			cachedProcess = &Process{
-				name:     key,
-				process:  proc,
-				cpuDelta: utils.Delta(uint64(nanoSeconds)),
-				cpuTime:  ewma.NewMovingAverage(60),
-			}
+		t.memTrend.Add(now, float64(snapshot.Memory()))
+		snapshot.memGrowth = t.memTrend.Growth()
+		c.Set(Kind, snapshot.ID(), snapshot)
+	}
+
+	for id := range trackers {
+		if _, ok := seen[id]; !ok {
+			delete(trackers, id)
 		}
-		cachedProcess.memUsage = memory.RSS / (1024. * 1024.) //convert byte to mega byte
-		c.Set(key, cachedProcess, time.Minute)
 	}
 }
 
-// MakeProcessesMap returns a map of process pid and process.Process instance for all running processes
-func makeProcessesMap() (processesMap, error) {
-	pMap := make(processesMap)
-
-	processesIds, err := process.Pids()
-	if err != nil {
-		return nil, err
+// memoryUsage returns the memory accounting of a process from smaps_rollup and falls back to the RSS
+// read from statm on kernels that don't provide it. smaps_rollup is only read again once smapsInterval
+// elapsed or the RSS changed by smapsChange, the RSS is always the one read from statm.
+func (t *tracker) memoryUsage(pid int32, stat procStat, now time.Time) utils.MemoryUsage {
+	rss := stat.rss / (1024 * 1024) //convert byte to mega byte
+	if !t.smapsAt.IsZero() && now.Sub(t.smapsAt) < smapsInterval &&
+		rss < t.smaps.RSS+smapsChange && rss+smapsChange > t.smaps.RSS {
+		usage := t.smaps
+		usage.RSS = rss
+		return usage
 	}
 
-	for _, pid := range processesIds {
-		p, err := process.NewProcess(pid)
-		if err != nil {
-			return nil, err
-		}
-		pMap[p.Pid] = p
+	usage, err := utils.ReadSmapsRollup(pid)
+	if err != nil {
+		return utils.MemoryUsage{RSS: rss, PSS: rss, USS: rss}
 	}
-
-	return pMap, nil
+	t.smaps, t.smapsAt = usage, now
+	return usage
 }
 
 // SetupWhiteList returns a map of pid and process.Process instance for whitelisted processes.
 func setupWhiteList(pMap processesMap) (whiteListMap, killableKidsPids) {
 	whiteList := make(whiteListMap)
 	killableKids := make(killableKidsPids)
-	for _, p := range pMap {
-		processName, err := p.Name()
-		if err != nil {
-			log.Errorf("Erorr getting process name for %v", p.Pid)
-			continue
-		}
-
-		_, ok := whitelistNames[processName]
+	for pid, stat := range pMap {
+		_, ok := whitelistNames[stat.name]
 		if !ok {
 			continue
 		}
-		whiteList[p.Pid] = struct{}{}
-		_, ok = killableKidsNames[processName]
+		whiteList[pid] = struct{}{}
+		_, ok = killableKidsNames[stat.name]
 		if !ok {
 			continue
 		}
-		killableKids[p.Pid] = struct{}{}
+		killableKids[pid] = struct{}{}
 	}
 
 	return whiteList, killableKids
@@ -177,20 +411,16 @@ func setupWhiteList(pMap processesMap) (whiteListMap, killableKidsPids) {
 // IsProcessKillable checks if a process can be killed or not.
 // A process can't be killed if it is a member of the whiteList or if it is a child of a process in the
 // whiteList.
-func isProcessKillable(p *process.Process, pMap processesMap, whiteList whiteListMap, killableKids killableKidsPids) (bool, error) {
-	_, ok := whiteList[p.Pid]
+func isProcessKillable(pid int32, pMap processesMap, whiteList whiteListMap, killableKids killableKidsPids) (bool, error) {
+	_, ok := whiteList[pid]
 	if ok {
 		return false, nil
 	}
-	return isParentKillable(p, pMap, whiteList, killableKids)
+	return isParentKillable(pid, pMap, whiteList, killableKids)
 }
 
-func isParentKillable(p *process.Process, pMap processesMap, whiteList whiteListMap, killableKids killableKidsPids) (bool, error) {
-	pPid, err := p.Ppid()
-	if err != nil {
-		log.Errorf("Error getting parent pid for pid %v", p.Pid)
-		return false, err
-	}
+func isParentKillable(pid int32, pMap processesMap, whiteList whiteListMap, killableKids killableKidsPids) (bool, error) {
+	pPid := pMap[pid].ppid
 
 	_, ok := whiteList[pPid]
 	if ok {
@@ -201,11 +431,11 @@ func isParentKillable(p *process.Process, pMap processesMap, whiteList whiteList
 		return false, nil
 	}
 
-	parent, inMap := pMap[pPid]
+	_, inMap := pMap[pPid]
 	if inMap != true {
-		message := fmt.Sprintf("Error getting parent process %v of process %v from process map", pPid, p.Pid)
+		message := fmt.Sprintf("Error getting parent process %v of process %v from process map", pPid, pid)
 		log.Error(message)
 		return false, fmt.Errorf(message)
 	}
-	return isParentKillable(parent, pMap, whiteList, killableKids)
+	return isParentKillable(pPid, pMap, whiteList, killableKids)
 }
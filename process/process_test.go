@@ -0,0 +1,256 @@
+package process
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// TestConcurrentCollectionAndAction collects the processes while monitors read the snapshots and act on them,
+// it is meant to be run with the race detector.
+func TestConcurrentCollectionAndAction(t *testing.T) {
+	// Make the children of the test binary killable, the other processes are not collected
+	// unless they descend from a whitelisted process.
+	self := filepath.Base(os.Args[0])
+	if len(self) > 15 {
+		self = self[:15]
+	}
+	whitelistNames[self] = struct{}{}
+	killableKidsNames[self] = struct{}{}
+	defer func() {
+		delete(whitelistNames, self)
+		delete(killableKidsNames, self)
+	}()
+
+	child := exec.Command("sleep", "60")
+	if err := child.Start(); err != nil {
+		t.Skipf("failed to start a child process: %v", err)
+	}
+	defer child.Process.Kill()
+	go child.Wait()
+	pid := int32(child.Process.Pid)
+	stat, err := readStat(pid)
+	if err != nil {
+		t.Fatalf("failed to read the stat of the child process: %v", err)
+	}
+	name := identity{pid, stat.startTime}.String()
+
+	r := registry.New()
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	// collector
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			UpdateCache(r)
+		}
+		close(done)
+	}()
+
+	// monitors reading the snapshots
+	for m := 0; m < 3; m++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				r.Range(func(value interface{}) bool {
+					p := value.(*Process)
+					_ = p.CPU() + p.SpawnRate() + p.MemoryGrowth().Rate
+					_ = p.Memory() + p.Swap().Used + p.Threads()
+					_ = p.Blocked()
+					_ = p.Realtime()
+					return true
+				})
+			}
+		}()
+	}
+
+	// monitor acting on the child
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			value, ok := r.Get(Kind, name)
+			if !ok {
+				continue
+			}
+			p := value.(*Process)
+			if err := p.Renice(10); err != nil {
+				t.Logf("failed to renice: %v", err)
+			}
+			if err := p.IoNice(utils.IoprioClassIdle, 0); err != nil {
+				t.Logf("failed to set I/O priority: %v", err)
+			}
+			if err := p.RestorePriority(); err != nil {
+				t.Logf("failed to restore priority: %v", err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	value, ok := r.Get(Kind, name)
+	if !ok {
+		t.Fatalf("expected the child process %v to be collected", name)
+	}
+	p := value.(*Process)
+	if err := p.Kill(); err != nil {
+		t.Fatalf("failed to kill the child process: %v", err)
+	}
+	if !r.Remove(p) {
+		t.Error("expected the killed process to be removed")
+	}
+}
+
+// newFakeSystem makes ORK use a fake host running the processes of a fake system
+func newFakeSystem(t *testing.T) (*host.Fake, *FakeSystem) {
+	f := host.NewFake()
+	f.Set("/dev/kmsg", "")
+	s := NewFakeSystem(f)
+	previousHost := host.Use(f)
+	previousSystem := UseSystem(s)
+	t.Cleanup(func() {
+		host.Use(previousHost)
+		UseSystem(previousSystem)
+	})
+	return f, s
+}
+
+// collected returns the snapshot of the process with pid collected in r
+func collected(t *testing.T, r *registry.Registry, pid int32) *Process {
+	var found *Process
+	r.Range(func(value interface{}) bool {
+		if p, ok := value.(*Process); ok && p.id.pid == pid {
+			found = p
+		}
+		return found == nil
+	})
+	if found == nil {
+		t.Fatalf("expected process %v to be collected", pid)
+	}
+	return found
+}
+
+// failingSystem is a FakeSystem whose niceness changes fail while fail is set
+type failingSystem struct {
+	*FakeSystem
+	fail bool
+}
+
+func (s *failingSystem) SetNice(tid int, nice int) error {
+	if s.fail {
+		return syscall.EPERM
+	}
+	return s.FakeSystem.SetNice(tid, nice)
+}
+
+func TestRestorePriorityRetries(t *testing.T) {
+	_, fake := newFakeSystem(t)
+	s := &failingSystem{FakeSystem: fake}
+	UseSystem(s)
+	fake.Start(FakeProcess{Pid: 100, Name: "hog", Threads: 2})
+	fake.SetNice(100, 5)
+	fake.SetNice(101, 5)
+	r := registry.New()
+	UpdateCache(r)
+	p := collected(t, r, 100)
+	t.Cleanup(func() { delete(originals, p.id) })
+
+	if err := p.Renice(19); err != nil {
+		t.Fatal(err)
+	}
+	s.fail = true
+	if err := p.RestorePriority(); err == nil {
+		t.Fatal("expected the restore to fail")
+	}
+	s.fail = false
+	if err := p.RestorePriority(); err != nil {
+		t.Fatal(err)
+	}
+	for tid := 100; tid < 102; tid++ {
+		if nice, _ := fake.Nice(tid); nice != 5 {
+			t.Errorf("expected the niceness of thread %v to be restored to 5 by the retry, got %v", tid, nice)
+		}
+	}
+	if _, ok := originals[p.id]; ok {
+		t.Error("expected the original priorities to be forgotten once restored")
+	}
+
+	// the original priorities of a process that is gone are forgotten
+	if err := p.Renice(19); err != nil {
+		t.Fatal(err)
+	}
+	fake.Exit(100)
+	if err := p.RestorePriority(); err == nil {
+		t.Fatal("expected the restore of a process that is gone to fail")
+	}
+	if _, ok := originals[p.id]; ok {
+		t.Error("expected the original priorities of a process that is gone to be forgotten")
+	}
+}
+
+func TestKillOriginTree(t *testing.T) {
+	_, s := newFakeSystem(t)
+	// a shell runs make which runs the hung compiler and another job, the editor is started by core0 too
+	s.Start(FakeProcess{Pid: 100, Name: "sh"})
+	s.Start(FakeProcess{Pid: 101, PPid: 100, Name: "make"})
+	s.Start(FakeProcess{Pid: 102, PPid: 101, Name: "cc"})
+	s.Start(FakeProcess{Pid: 103, PPid: 100, Name: "job"})
+	s.Start(FakeProcess{Pid: 200, Name: "editor"})
+	r := registry.New()
+	UpdateCache(r)
+
+	if err := collected(t, r, 102).KillOriginTree(); err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range []int32{100, 101, 102, 103} {
+		if !s.Killed(pid) {
+			t.Errorf("expected process %v of the originating tree to be killed", pid)
+		}
+	}
+	if s.Killed(200) || s.Killed(1) {
+		t.Error("expected the processes outside of the originating tree not to be killed")
+	}
+}
+
+func TestMount(t *testing.T) {
+	f, s := newFakeSystem(t)
+	f.Set("/proc/self/mountinfo", "20 1 8:1 / / rw - ext4 /dev/sda1 rw\n"+
+		"21 20 0:5 / /proc rw - proc proc rw\n"+
+		"22 20 0:40 / /mnt/nfs\\040share rw - nfs4 server:/share rw\n")
+	s.Start(FakeProcess{Pid: 100, Name: "reader"})
+	f.Link("/proc/100/cwd", "/")
+	f.Link("/proc/100/fd/0", "/dev/pts/0")
+	f.Link("/proc/100/fd/1", "pipe:[1234]")
+	f.Link("/proc/100/fd/2", "/proc/100/status")
+	f.Link("/proc/100/fd/3", "/mnt/nfs share/data")
+	s.Start(FakeProcess{Pid: 200, Name: "idle"})
+	f.Link("/proc/200/cwd", "/home")
+	r := registry.New()
+	UpdateCache(r)
+
+	if mount := collected(t, r, 100).Mount(); mount != "/mnt/nfs share" {
+		t.Errorf("expected the files of the reader to be on /mnt/nfs share, got %q", mount)
+	}
+	if mount := collected(t, r, 200).Mount(); mount != "/" {
+		t.Errorf("expected the files of idle to be on /, got %q", mount)
+	}
+}
@@ -0,0 +1,324 @@
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/zero-os/0-ork/host"
+	"golang.org/x/sys/unix"
+)
+
+// atClkTck is the type of the entry of the auxiliary vector holding USER_HZ as defined in linux/auxvec.h
+const atClkTck = 17
+
+// clockTicks is the number of clock ticks per second (USER_HZ) the cpu times of /proc/<pid>/stat are counted in
+var clockTicks = userHZ()
+
+// userHZ returns USER_HZ as sysconf(_SC_CLK_TCK) does, from the auxiliary vector the kernel passed to ORK.
+// It falls back to 100 which is USER_HZ on all the architectures ORK runs on.
+func userHZ() uint64 {
+	auxv, err := ioutil.ReadFile("/proc/self/auxv")
+	if err != nil {
+		return 100
+	}
+	// entries are pairs of native words: type value
+	const word = int(unsafe.Sizeof(uintptr(0)))
+	for i := 0; i+2*word <= len(auxv); i += 2 * word {
+		if *(*uintptr)(unsafe.Pointer(&auxv[i])) != atClkTck {
+			continue
+		}
+		if hz := *(*uintptr)(unsafe.Pointer(&auxv[i+word])); hz > 0 {
+			return uint64(hz)
+		}
+		break
+	}
+	return 100
+}
+
+// statFields is the number of fields of /proc/<pid>/stat following the process name that are parsed
+const statFields = 39
+
+var pageSize = uint64(os.Getpagesize())
+
+// procStat holds the fields of /proc/<pid>/stat and /proc/<pid>/statm used by ORK
+type procStat struct {
+	name        string
+	state       byte
+	ppid        int32
+	majorFaults uint64
+	utime       uint64
+	stime       uint64
+	threads     uint64
+	startTime   uint64
+	nice        int
+	policy      int
+	rtPriority  int
+	rss         uint64
+}
+
+// cpuTime returns the cpu time consumed by the process in nanoseconds
+func (s *procStat) cpuTime() uint64 {
+	return (s.utime + s.stime) * (1000000000 / clockTicks)
+}
+
+// scanner reads the stat and statm files of all processes in one pass. The buffers and the map of stats
+// are reused between scans, the result of a scan is only valid until the next one.
+type scanner struct {
+	buf    []byte
+	path   []byte
+	fields [statFields][]byte
+	stats  processesMap
+}
+
+func newScanner() *scanner {
+	return &scanner{
+		buf:   make([]byte, 4096),
+		stats: make(processesMap),
+	}
+}
+
+// vanished returns true if err means that the process exited while it was read
+func vanished(err error) bool {
+	return os.IsNotExist(err) || err == syscall.ESRCH
+}
+
+// listPids returns the pids of all running processes
+func listPids() ([]int32, error) {
+	names, err := host.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int32, 0, len(names))
+	for _, name := range names {
+		if pid, err := strconv.ParseInt(name, 10, 32); err == nil {
+			pids = append(pids, int32(pid))
+		}
+	}
+	return pids, nil
+}
+
+// scan returns the stat of all running processes, the processes that exit during the scan are skipped
+func (s *scanner) scan() (processesMap, error) {
+	pids, err := listPids()
+	if err != nil {
+		return nil, err
+	}
+	for pid := range s.stats {
+		delete(s.stats, pid)
+	}
+
+	for _, pid := range pids {
+		stat, err := s.read(pid)
+		if err != nil {
+			if !vanished(err) {
+				log.Errorf("Error reading stat of process %v: %v", pid, err)
+			}
+			continue
+		}
+		s.stats[pid] = stat
+	}
+	return s.stats, nil
+}
+
+// read returns the stat of a process
+func (s *scanner) read(pid int32) (procStat, error) {
+	contents, err := s.readFile(pid, "stat")
+	if err != nil {
+		return procStat{}, err
+	}
+	stat, err := s.parseStat(pid, contents)
+	if err != nil {
+		return procStat{}, err
+	}
+
+	// statm fields are in pages: size resident shared text lib data dt
+	contents, err = s.readFile(pid, "statm")
+	if err != nil {
+		return procStat{}, err
+	}
+	if n := splitFields(contents, s.fields[:2]); n < 2 {
+		return procStat{}, fmt.Errorf("Invalid statm for process %v", pid)
+	}
+	resident, err := parseUint(s.fields[1])
+	if err != nil {
+		return procStat{}, err
+	}
+	stat.rss = resident * pageSize
+	return stat, nil
+}
+
+// readFile reads /proc/<pid>/<name> into the buffer of the scanner, the contents are only valid until
+// the next read. The files of a host directory are read directly without allocating.
+func (s *scanner) readFile(pid int32, name string) ([]byte, error) {
+	s.path = s.path[:0]
+	dir, direct := host.Current().(host.Dir)
+	if direct {
+		s.path = append(s.path, strings.TrimSuffix(string(dir), "/")...)
+	}
+	s.path = append(s.path, "/proc/"...)
+	s.path = strconv.AppendInt(s.path, int64(pid), 10)
+	s.path = append(s.path, '/')
+	s.path = append(s.path, name...)
+
+	if !direct {
+		contents, err := host.ReadFile(string(s.path))
+		if err != nil {
+			return nil, err
+		}
+		return contents, nil
+	}
+	s.path = append(s.path, 0)
+
+	fd, err := open(s.path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: string(s.path[:len(s.path)-1]), Err: err}
+	}
+	defer syscall.Close(fd)
+
+	var n int
+	for {
+		if n == len(s.buf) {
+			s.buf = append(s.buf, make([]byte, len(s.buf))...)
+		}
+		read, err := syscall.Read(fd, s.buf[n:])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if read == 0 {
+			return s.buf[:n], nil
+		}
+		n += read
+	}
+}
+
+// open opens the NUL terminated path for reading, unlike syscall.Open it doesn't allocate a copy of the path
+func open(path []byte) (int, error) {
+	dirfd := unix.AT_FDCWD
+	for {
+		fd, _, errno := syscall.Syscall6(syscall.SYS_OPENAT, uintptr(dirfd), uintptr(unsafe.Pointer(&path[0])),
+			uintptr(syscall.O_RDONLY|syscall.O_CLOEXEC), 0, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return -1, errno
+		}
+		return int(fd), nil
+	}
+}
+
+// parseStat parses the contents of /proc/<pid>/stat
+func (s *scanner) parseStat(pid int32, contents []byte) (procStat, error) {
+	// The process name is between parentheses and may contain spaces,
+	// the fields are read from the last closing parenthesis.
+	start := bytes.IndexByte(contents, '(')
+	end := bytes.LastIndexByte(contents, ')')
+	if start < 0 || end < start {
+		return procStat{}, fmt.Errorf("Invalid stat for process %v", pid)
+	}
+	// fields[0] is the third field of the stat file (state)
+	fields := s.fields[:]
+	if splitFields(contents[end+1:], fields) < statFields {
+		return procStat{}, fmt.Errorf("Invalid stat for process %v", pid)
+	}
+
+	stat := procStat{
+		name:  string(contents[start+1 : end]),
+		state: fields[0][0],
+	}
+	ppid, err := parseInt(fields[1])
+	if err != nil {
+		return procStat{}, err
+	}
+	stat.ppid = int32(ppid)
+	if stat.majorFaults, err = parseUint(fields[9]); err != nil {
+		return procStat{}, err
+	}
+	if stat.utime, err = parseUint(fields[11]); err != nil {
+		return procStat{}, err
+	}
+	if stat.stime, err = parseUint(fields[12]); err != nil {
+		return procStat{}, err
+	}
+	nice, err := parseInt(fields[16])
+	if err != nil {
+		return procStat{}, err
+	}
+	stat.nice = int(nice)
+	if stat.threads, err = parseUint(fields[17]); err != nil {
+		return procStat{}, err
+	}
+	if stat.startTime, err = parseUint(fields[19]); err != nil {
+		return procStat{}, err
+	}
+	rtPriority, err := parseInt(fields[37])
+	if err != nil {
+		return procStat{}, err
+	}
+	stat.rtPriority = int(rtPriority)
+	policy, err := parseInt(fields[38])
+	if err != nil {
+		return procStat{}, err
+	}
+	stat.policy = int(policy)
+	return stat, nil
+}
+
+// readStat parses /proc/<pid>/stat and /proc/<pid>/statm of a single process
+func readStat(pid int32) (procStat, error) {
+	return newScanner().read(pid)
+}
+
+// splitFields splits b around spaces into fields without allocating and returns the number of fields found,
+// it stops once fields is full.
+func splitFields(b []byte, fields [][]byte) int {
+	var n int
+	for i := 0; i < len(b) && n < len(fields); {
+		for i < len(b) && (b[i] == ' ' || b[i] == '\n') {
+			i++
+		}
+		start := i
+		for i < len(b) && b[i] != ' ' && b[i] != '\n' {
+			i++
+		}
+		if i > start {
+			fields[n] = b[start:i]
+			n++
+		}
+	}
+	return n
+}
+
+// parseUint parses a decimal unsigned integer
+func parseUint(b []byte) (uint64, error) {
+	if len(b) == 0 {
+		return 0, fmt.Errorf("Invalid number %q", b)
+	}
+	var value uint64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("Invalid number %q", b)
+		}
+		value = value*10 + uint64(c-'0')
+	}
+	return value, nil
+}
+
+// parseInt parses a decimal integer that may be negative
+func parseInt(b []byte) (int64, error) {
+	if len(b) > 0 && b[0] == '-' {
+		value, err := parseUint(b[1:])
+		return -int64(value), err
+	}
+	value, err := parseUint(b)
+	return int64(value), err
+}
@@ -0,0 +1,252 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/registry"
+)
+
+// fakeStat returns the contents of the stat and statm files of a fake process. The process 1 is core0 and
+// the other processes are its children, so they are all collected.
+func fakeStat(pid int) (string, string) {
+	name, ppid := fmt.Sprintf("worker %v", pid), 1
+	if pid == 1 {
+		name, ppid = "core0", 0
+	}
+	stat := fmt.Sprintf("%v (%v) S %v %v %v 0 -1 4194560 1200 0 %v 0 %v %v 0 0 20 -5 4 0 %v "+
+		"184320000 2048 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 3 0 0 0 0 0 0 0 0 0 0 0 0 0\n",
+		pid, name, ppid, pid, pid, pid%7, pid*3, pid*2, 1000+pid)
+	statm := fmt.Sprintf("45000 %v 900 200 0 3000 0\n", pid)
+	return stat, statm
+}
+
+// fakeRollup is the smaps_rollup file of a fake process
+const fakeRollup = `00400000-7ffc3b9fe000 ---p 00000000 00:00 0                          [rollup]
+Rss:               20480 kB
+Pss:               12288 kB
+Pss_Anon:           8192 kB
+Pss_File:           4096 kB
+Pss_Shmem:             0 kB
+Shared_Clean:      10240 kB
+Shared_Dirty:          0 kB
+Private_Clean:      2048 kB
+Private_Dirty:      8192 kB
+Referenced:        20480 kB
+Anonymous:          8192 kB
+LazyFree:              0 kB
+AnonHugePages:         0 kB
+ShmemPmdMapped:        0 kB
+FilePmdMapped:         0 kB
+Shared_Hugetlb:        0 kB
+Private_Hugetlb:       0 kB
+Swap:               1024 kB
+SwapPss:             512 kB
+Locked:                0 kB
+`
+
+// fakeProcfs creates a host directory with count processes and makes ORK use it
+func fakeProcfs(tb testing.TB, count int) host.Dir {
+	dir, err := ioutil.TempDir("", "procfs")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	root := host.Dir(dir)
+	for pid := 1; pid <= count; pid++ {
+		stat, statm := fakeStat(pid)
+		if err := os.MkdirAll(root.Path(fmt.Sprintf("/proc/%v", pid)), 0755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := ioutil.WriteFile(root.Path(fmt.Sprintf("/proc/%v/stat", pid)), []byte(stat), 0644); err != nil {
+			tb.Fatal(err)
+		}
+		if err := ioutil.WriteFile(root.Path(fmt.Sprintf("/proc/%v/statm", pid)), []byte(statm), 0644); err != nil {
+			tb.Fatal(err)
+		}
+		files := map[string]string{
+			"status":       "Name:\tworker\nUid:\t1000\t1000\t1000\t1000\n",
+			"cgroup":       "0::/system.slice/worker.service\n",
+			"smaps_rollup": fakeRollup,
+		}
+		for name, contents := range files {
+			if err := ioutil.WriteFile(root.Path(fmt.Sprintf("/proc/%v/%v", pid, name)), []byte(contents), 0644); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	original := host.Use(root)
+	tb.Cleanup(func() {
+		host.Use(original)
+		os.RemoveAll(dir)
+		trackers = make(map[identity]*tracker)
+		lastScan = time.Time{}
+	})
+	return root
+}
+
+func TestScan(t *testing.T) {
+	root := fakeProcfs(t, 3)
+	// a process that exited after its directory was listed
+	if err := os.Mkdir(root.Path("/proc/4"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// entries of /proc that are not processes
+	if err := os.Mkdir(root.Path("/proc/sys"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	checkScan(t)
+
+	fake := host.NewFake()
+	for pid := 1; pid <= 3; pid++ {
+		stat, statm := fakeStat(pid)
+		fake.Set(fmt.Sprintf("/proc/%v/stat", pid), stat)
+		fake.Set(fmt.Sprintf("/proc/%v/statm", pid), statm)
+	}
+	fake.Set("/proc/4/status", "")
+	fake.Set("/proc/sys/kernel/pid_max", "32768")
+	host.Use(fake)
+	checkScan(t)
+}
+
+// checkScan checks the scan of the 3 processes created by fakeStat
+func checkScan(t *testing.T) {
+	stats, err := newScanner().scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 processes, got %v", len(stats))
+	}
+	stat := stats[2]
+	expected := procStat{
+		name:        "worker 2",
+		state:       'S',
+		ppid:        1,
+		majorFaults: 2,
+		utime:       6,
+		stime:       4,
+		threads:     4,
+		startTime:   1002,
+		nice:        -5,
+		rtPriority:  0,
+		policy:      0,
+		rss:         2 * pageSize,
+	}
+	if stat != expected {
+		t.Errorf("expected %+v, got %+v", expected, stat)
+	}
+	if stat.cpuTime() != 100000000 {
+		t.Errorf("expected 100ms of cpu time, got %v", stat.cpuTime())
+	}
+}
+
+func TestScanReusesBuffers(t *testing.T) {
+	fakeProcfs(t, 100)
+	s := newScanner()
+	if _, err := s.scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Listing /proc allocates, reading and parsing the processes only allocates their names
+	allocs := testing.AllocsPerRun(10, func() {
+		s.scan()
+	})
+	if allocs > 300 {
+		t.Errorf("expected at most 3 allocations per process, got %v per scan", allocs)
+	}
+}
+
+func BenchmarkScan10k(b *testing.B) {
+	fakeProcfs(b, 10000)
+	s := newScanner()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.scan(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUpdateCache10k measures the collection of 10k processes run every second by the cache loop. The
+// smaps_rollup files are read for all the processes in the smaps run, which happens every smapsInterval, and only
+// the stat and statm files are read in the statm run.
+func BenchmarkUpdateCache10k(b *testing.B) {
+	fakeProcfs(b, 10000)
+	r := registry.New()
+	UpdateCache(r)
+
+	b.Run("smaps", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			for _, t := range trackers {
+				t.smapsAt = time.Time{}
+			}
+			b.StartTimer()
+			UpdateCache(r)
+		}
+	})
+	b.Run("statm", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			UpdateCache(r)
+		}
+	})
+}
+
+func TestUpdateCacheReadsSmapsPeriodically(t *testing.T) {
+	root := fakeProcfs(t, 3)
+	r := registry.New()
+	UpdateCache(r)
+
+	expected := identity{2, 1002}
+	value, ok := r.Get(Kind, expected.String())
+	if !ok {
+		t.Fatal("expected the child of core0 to be collected")
+	}
+	if usage := value.(*Process).MemoryUsage(); usage.PSS != 12 || usage.USS != 10 {
+		t.Fatalf("expected the accounting of smaps_rollup, got %+v", usage)
+	}
+
+	// smaps_rollup is not read again before smapsInterval unless the RSS changes
+	rollup := strings.Replace(fakeRollup, "Pss:               12288 kB", "Pss:               16384 kB", 1)
+	if err := ioutil.WriteFile(root.Path("/proc/2/smaps_rollup"), []byte(rollup), 0644); err != nil {
+		t.Fatal(err)
+	}
+	UpdateCache(r)
+	value, _ = r.Get(Kind, expected.String())
+	if usage := value.(*Process).MemoryUsage(); usage.PSS != 12 {
+		t.Errorf("expected the accounting to be kept within smapsInterval, got %+v", usage)
+	}
+
+	trackers[expected].smapsAt = time.Now().Add(-smapsInterval)
+	UpdateCache(r)
+	value, _ = r.Get(Kind, expected.String())
+	if usage := value.(*Process).MemoryUsage(); usage.PSS != 16 {
+		t.Errorf("expected smaps_rollup to be read again after smapsInterval, got %+v", usage)
+	}
+}
+
+func TestUserHZ(t *testing.T) {
+	if hz := userHZ(); hz != 100 {
+		t.Errorf("expected USER_HZ to be 100, got %v", hz)
+	}
+}
+
+func BenchmarkParseStat(b *testing.B) {
+	s := newScanner()
+	contents := []byte("4242 (qemu-system-x86) S 1 4242 4242 0 -1 4194560 1200 0 3 0 12345 678 0 0 20 0 32 0 98765 " +
+		"184320000 2048 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 3 0 0 0 0 0 0 0 0 0 0 0 0 0\n")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.parseStat(4242, contents); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
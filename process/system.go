@@ -0,0 +1,85 @@
+package process
+
+import (
+	"syscall"
+
+	"github.com/zero-os/0-ork/utils"
+	"golang.org/x/sys/unix"
+)
+
+// System makes the system calls ORK acts on processes with
+type System interface {
+	// Signal sends sig to the process with pid if it was started at startTime
+	Signal(pid int32, startTime uint64, sig syscall.Signal) error
+	// Nice returns the niceness of a thread
+	Nice(tid int) (int, error)
+	// SetNice sets the niceness of a thread
+	SetNice(tid int, nice int) error
+	// IoPriority returns the I/O scheduling class and level of a thread
+	IoPriority(tid int) (int, int, error)
+	// SetIoPriority sets the I/O scheduling class and level of a thread
+	SetIoPriority(tid int, class int, level int) error
+	// SetScheduler sets the scheduling policy and realtime priority of a thread
+	SetScheduler(tid int, policy int, priority int) error
+}
+
+// hostSystem is the System of the host
+type hostSystem struct{}
+
+// system is the System used by ORK, it is only replaced by tests before the monitors are started
+var system System = hostSystem{}
+
+// UseSystem makes ORK use s and returns the System used before, it is meant for tests
+func UseSystem(s System) System {
+	previous := system
+	system = s
+	return previous
+}
+
+// Signal opens the process as a pidfd before its identity is verified so that the signal can't reach a new
+// process that reused the pid in between. On kernels without pidfd support the identity is verified right
+// before the signal is sent.
+func (hostSystem) Signal(pid int32, startTime uint64, sig syscall.Signal) error {
+	id := identity{pid, startTime}
+	fd, err := unix.PidfdOpen(int(pid), 0)
+	if err == unix.ENOSYS {
+		if err := id.verify(); err != nil {
+			return err
+		}
+		return syscall.Kill(int(pid), sig)
+	}
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	if err := id.verify(); err != nil {
+		return err
+	}
+	return unix.PidfdSendSignal(fd, sig, nil, 0)
+}
+
+func (hostSystem) Nice(tid int) (int, error) {
+	// getpriority returns 20 - nice so that the result of the syscall is never negative
+	prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, tid)
+	if err != nil {
+		return 0, err
+	}
+	return 20 - prio, nil
+}
+
+func (hostSystem) SetNice(tid int, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice)
+}
+
+func (hostSystem) IoPriority(tid int) (int, int, error) {
+	return utils.GetIoPriority(tid)
+}
+
+func (hostSystem) SetIoPriority(tid int, class int, level int) error {
+	return utils.SetIoPriority(tid, class, level)
+}
+
+func (hostSystem) SetScheduler(tid int, policy int, priority int) error {
+	return utils.SetScheduler(tid, policy, priority)
+}
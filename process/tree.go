@@ -0,0 +1,195 @@
+package process
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// killTreeRounds is the maximum number of times the process tree is walked when stopping it, to catch
+// the processes forked while the previous round was stopping the tree.
+const killTreeRounds = 3
+
+// knownPids holds the pids seen by the previous scan
+var knownPids map[int32]struct{}
+
+// countBirths returns the number of processes created since the previous scan under each pid of pMap.
+// A process is counted for all its ancestors, so the count of a process includes the counts of its children.
+func countBirths(pMap processesMap) map[int32]uint64 {
+	births := make(map[int32]uint64)
+	ppid := func(pid int32) (int32, bool) {
+		stat, ok := pMap[pid]
+		return stat.ppid, ok
+	}
+
+	first := knownPids == nil
+	seen := make(map[int32]struct{}, len(pMap))
+	for pid := range pMap {
+		seen[pid] = struct{}{}
+		if _, ok := knownPids[pid]; ok || first {
+			continue
+		}
+		for parent, ok := ppid(pid); ok && parent > 0; parent, ok = ppid(parent) {
+			births[parent]++
+		}
+	}
+	knownPids = seen
+	return births
+}
+
+// readUID returns the real uid of a process as reported by /proc/<pid>/status
+func readUID(pid int32) (uint32, error) {
+	contents, err := host.ReadFile(fmt.Sprintf("/proc/%v/status", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// formatted as: Uid: real effective saved filesystem
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "Uid:" {
+			continue
+		}
+		uid, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return 0, err
+		}
+		return uint32(uid), nil
+	}
+	return 0, fmt.Errorf("Error getting owner of process %v", pid)
+}
+
+// readCgroup returns the cgroup of a process, the unified hierarchy is preferred if available
+func readCgroup(pid int32) (string, error) {
+	contents, err := host.ReadFile(fmt.Sprintf("/proc/%v/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+
+	// lines are formatted as: hierarchy-ID:controller-list:cgroup-path
+	var cgroup string
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" {
+			return fields[2], nil
+		}
+		if cgroup == "" || strings.Contains(fields[1], "pids") {
+			cgroup = fields[2]
+		}
+	}
+	return cgroup, nil
+}
+
+// subtree returns the descendants of root including itself, whitelisted processes and their
+// descendants are excluded.
+func subtree(root identity) ([]identity, error) {
+	stats, err := newScanner().scan()
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[int32][]identity)
+	for child, stat := range stats {
+		if _, ok := whitelistNames[stat.name]; ok {
+			continue
+		}
+		children[stat.ppid] = append(children[stat.ppid], identity{child, stat.startTime})
+	}
+
+	tree := []identity{root}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i].pid]...)
+	}
+	return tree, nil
+}
+
+// origin returns the ancestor of the process started by a whitelisted process, that is the userspace process
+// the process originates from
+func origin(id identity) (identity, error) {
+	stats, err := newScanner().scan()
+	if err != nil {
+		return identity{}, err
+	}
+	if stat, ok := stats[id.pid]; !ok || stat.startTime != id.startTime {
+		return identity{}, fmt.Errorf("Process %v is gone", id.pid)
+	}
+
+	for {
+		stat := stats[id.pid]
+		parent, ok := stats[stat.ppid]
+		if !ok {
+			return id, nil
+		}
+		if _, ok := whitelistNames[parent.name]; ok {
+			return id, nil
+		}
+		id = identity{stat.ppid, parent.startTime}
+	}
+}
+
+// KillTree kills the process and all its descendants. The whole tree is stopped before it is killed so that
+// it can't fork faster than it is killed.
+func (p *Process) KillTree() error {
+	return killTree(p.id)
+}
+
+// KillOriginTree kills the process tree of the userspace process the process originates from, which stops
+// the processes that keep issuing the requests the process is blocked on
+func (p *Process) KillOriginTree() error {
+	root, err := origin(p.id)
+	if err != nil {
+		log.Errorf("Error getting origin of process %v: %v", p.id.pid, err)
+		return err
+	}
+	return killTree(root)
+}
+
+func killTree(root identity) error {
+	pid := root.pid
+	utils.LogToKernel("ORK: attempting to kill process tree of pid %v\n", pid)
+
+	stopped := make(map[identity]struct{})
+	for round := 0; round < killTreeRounds; round++ {
+		tree, err := subtree(root)
+		if err != nil {
+			log.Errorf("Error getting process tree of %v: %v", pid, err)
+			return err
+		}
+		var stopping int
+		for _, child := range tree {
+			if _, ok := stopped[child]; ok {
+				continue
+			}
+			child.signal(syscall.SIGSTOP)
+			stopped[child] = struct{}{}
+			stopping++
+		}
+		if stopping == 0 {
+			break
+		}
+	}
+
+	var killed int
+	for child := range stopped {
+		if err := child.signal(syscall.SIGKILL); err == nil {
+			killed++
+		}
+	}
+
+	if killed == 0 {
+		err := fmt.Errorf("Error killing process tree of %v", pid)
+		utils.LogToKernel("ORK: error killing process tree of pid %v\n", pid)
+		log.Error(err)
+		return err
+	}
+	utils.LogToKernel("ORK: successfully killed %v processes in tree of pid %v\n", killed, pid)
+	log.Infof("Successfully killed %v processes in tree of pid %v", killed, pid)
+	return nil
+}
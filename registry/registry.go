@@ -0,0 +1,207 @@
+// Package registry holds the activities collected by ORK under keys namespaced by their kind
+package registry
+
+import (
+	"sync"
+	"time"
+)
+
+// Kind is the type of an activity, it namespaces the keys of the activities and defines after how long
+// an activity that is not set again expires. Activities of a kind with a zero Expiry never expire.
+type Kind struct {
+	Name   string
+	Expiry time.Duration
+}
+
+// Activity is implemented by the values stored in the registry that can be removed with Remove,
+// ID returns the name the activity is stored under.
+type Activity interface {
+	Kind() Kind
+	ID() string
+}
+
+// Event is the change that triggered a listener
+type Event int
+
+const (
+	Added Event = iota
+	Removed
+)
+
+func (e Event) String() string {
+	if e == Added {
+		return "added"
+	}
+	return "removed"
+}
+
+// Listener is called after an activity is added to or removed from the registry, replacing the value
+// of an activity is not notified.
+type Listener func(event Event, key string, value interface{})
+
+type entry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+type notification struct {
+	event Event
+	key   string
+	value interface{}
+}
+
+// Registry is a set of activities safe for concurrent use
+type Registry struct {
+	lock      sync.RWMutex
+	entries   map[string]*entry
+	listeners []Listener
+}
+
+func New() *Registry {
+	return &Registry{
+		entries: make(map[string]*entry),
+	}
+}
+
+// Key returns the key of the activity name of kind
+func Key(kind Kind, name string) string {
+	return kind.Name + "/" + name
+}
+
+// Listen adds a listener called on every addition and removal
+func (r *Registry) Listen(listener Listener) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.listeners = append(r.listeners, listener)
+}
+
+// notify calls the listeners, it must be called without holding r.lock
+func (r *Registry) notify(notifications []notification) {
+	if len(notifications) == 0 {
+		return
+	}
+	r.lock.RLock()
+	listeners := r.listeners
+	r.lock.RUnlock()
+
+	for _, n := range notifications {
+		for _, listener := range listeners {
+			listener(n.event, n.key, n.value)
+		}
+	}
+}
+
+// Get returns the activity name of kind if it is in the registry and not expired
+func (r *Registry) Get(kind Kind, name string) (interface{}, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	e, ok := r.entries[Key(kind, name)]
+	if !ok || e.expired(time.Now()) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Set stores value as the activity name of kind and resets its expiry.
+// The value previously stored for the activity is replaced.
+func (r *Registry) Set(kind Kind, name string, value interface{}) {
+	key := Key(kind, name)
+	var expires time.Time
+	if kind.Expiry > 0 {
+		expires = time.Now().Add(kind.Expiry)
+	}
+
+	r.lock.Lock()
+	_, ok := r.entries[key]
+	r.entries[key] = &entry{value: value, expires: expires}
+	r.lock.Unlock()
+
+	if !ok {
+		r.notify([]notification{{Added, key, value}})
+	}
+}
+
+// delete removes the entry of key, r.lock must be held
+func (r *Registry) delete(key string) (notification, bool) {
+	e, ok := r.entries[key]
+	if !ok {
+		return notification{}, false
+	}
+	delete(r.entries, key)
+	return notification{Removed, key, e.value}, true
+}
+
+// Delete removes the activity name of kind
+func (r *Registry) Delete(kind Kind, name string) {
+	r.lock.Lock()
+	n, ok := r.delete(Key(kind, name))
+	r.lock.Unlock()
+
+	if ok {
+		r.notify([]notification{n})
+	}
+}
+
+// Remove removes the activity activ is a snapshot of, activ doesn't need to be the snapshot currently stored.
+// It returns false if the activity is not in the registry.
+func (r *Registry) Remove(activ Activity) bool {
+	r.lock.Lock()
+	n, ok := r.delete(Key(activ.Kind(), activ.ID()))
+	r.lock.Unlock()
+
+	if ok {
+		r.notify([]notification{n})
+	}
+	return ok
+}
+
+// Purge removes the expired activities
+func (r *Registry) Purge() {
+	now := time.Now()
+	var notifications []notification
+
+	r.lock.Lock()
+	for key, e := range r.entries {
+		if !e.expired(now) {
+			continue
+		}
+		if n, ok := r.delete(key); ok {
+			notifications = append(notifications, n)
+		}
+	}
+	r.lock.Unlock()
+
+	r.notify(notifications)
+}
+
+// Range calls f for each activity that is not expired until f returns false. The registry is locked for reading
+// during the iteration so f must not modify it and should return quickly.
+func (r *Registry) Range(f func(value interface{}) bool) {
+	now := time.Now()
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, e := range r.entries {
+		if e.expired(now) {
+			continue
+		}
+		if !f(e.value) {
+			return
+		}
+	}
+}
+
+// Len returns the number of activities in the registry including the expired ones that were not purged yet
+func (r *Registry) Len() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return len(r.entries)
+}
@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var processKind = Kind{Name: "process", Expiry: time.Minute}
+var domainKind = Kind{Name: "domain"}
+
+type activity struct {
+	kind  Kind
+	name  string
+	value int
+}
+
+func (a *activity) Kind() Kind {
+	return a.kind
+}
+
+func (a *activity) ID() string {
+	return a.name
+}
+
+func TestNamespacedKeys(t *testing.T) {
+	r := New()
+	process := &activity{processKind, "1234", 1}
+	domain := &activity{domainKind, "1234", 2}
+	r.Set(processKind, "1234", process)
+	r.Set(domainKind, "1234", domain)
+
+	if r.Len() != 2 {
+		t.Fatalf("expected 2 activities, got %v", r.Len())
+	}
+	if v, ok := r.Get(processKind, "1234"); !ok || v != process {
+		t.Errorf("expected the process, got %v", v)
+	}
+
+	r.Remove(process)
+	if _, ok := r.Get(processKind, "1234"); ok {
+		t.Error("expected the process to be removed")
+	}
+	if v, ok := r.Get(domainKind, "1234"); !ok || v != domain {
+		t.Errorf("expected the domain to be kept, got %v", v)
+	}
+}
+
+func TestRemoveSnapshot(t *testing.T) {
+	r := New()
+	old := &activity{processKind, "1", 1}
+	r.Set(processKind, "1", old)
+	r.Set(processKind, "1", &activity{processKind, "1", 2})
+
+	if !r.Remove(old) {
+		t.Error("expected an old snapshot to remove its activity")
+	}
+	if r.Remove(old) {
+		t.Error("expected the activity to be removed once")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	r := New()
+	kind := Kind{Name: "short", Expiry: 10 * time.Millisecond}
+	r.Set(kind, "a", &activity{kind, "a", 1})
+	r.Set(domainKind, "b", &activity{domainKind, "b", 1})
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := r.Get(kind, "a"); ok {
+		t.Error("expected the activity to be expired")
+	}
+	count := 0
+	r.Range(func(interface{}) bool {
+		count++
+		return true
+	})
+	if count != 1 {
+		t.Errorf("expected Range to skip the expired activity, got %v activities", count)
+	}
+
+	r.Purge()
+	if r.Len() != 1 {
+		t.Errorf("expected the expired activity to be purged, got %v activities", r.Len())
+	}
+}
+
+func TestListeners(t *testing.T) {
+	r := New()
+	var events []string
+	r.Listen(func(event Event, key string, value interface{}) {
+		events = append(events, fmt.Sprintf("%v %v", event, key))
+	})
+
+	a := &activity{processKind, "1", 1}
+	r.Set(processKind, "1", a)
+	r.Set(processKind, "1", &activity{processKind, "1", 2})
+	r.Remove(a)
+
+	expected := []string{"added process/1", "removed process/1"}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Errorf("expected events %v, got %v", expected, events)
+	}
+}
+
+// TestConcurrentAccess publishes snapshots from a collector while monitors iterate over them and remove them,
+// it is meant to be run with the race detector.
+func TestConcurrentAccess(t *testing.T) {
+	r := New()
+	r.Listen(func(event Event, key string, value interface{}) {
+		_ = value.(*activity).value
+	})
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			for j := 0; j < 10; j++ {
+				name := fmt.Sprint(j)
+				r.Set(processKind, name, &activity{processKind, name, i})
+			}
+			r.Purge()
+		}
+		close(done)
+	}()
+
+	for m := 0; m < 4; m++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				var victim *activity
+				r.Range(func(value interface{}) bool {
+					a := value.(*activity)
+					if victim == nil || a.value > victim.value {
+						victim = a
+					}
+					return true
+				})
+				if victim != nil {
+					r.Remove(victim)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,96 @@
+// Package slab implements a monitor of the kernel slab memory
+package slab
+
+import (
+	"context"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/zero-os/0-ork/monitor"
+	"github.com/zero-os/0-ork/registry"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// growthRate is the growth in MB per minute above which a slab cache that grows steadily is reported
+const growthRate float64 = 1
+
+// trendInterval and trendWindow define how the size of slab caches is tracked over time
+const trendInterval = 10 * time.Second
+const trendWindow = 10 * time.Minute
+
+var log = logging.MustGetLogger("ORK")
+
+// trends holds the size in MB of each slab cache over time
+var trends = make(map[string]*utils.Trend)
+
+// growing holds the names of the slab caches a warning was emitted for
+var growing = make(map[string]struct{})
+var exceeded = false
+
+func init() {
+	monitor.Register(monitor.New("slab", trendInterval, func(ctx context.Context, c *registry.Registry) error {
+		return Monitor(c)
+	}))
+}
+
+// Monitor tracks the growth of each slab cache and raises events when the unreclaimable slab memory
+// exceeds the configured fraction of the total memory, which hints at a kernel memory leak.
+func Monitor(c *registry.Registry) error {
+	log.Debug("Monitoring slab")
+
+	caches, err := readSlabInfo()
+	if err != nil {
+		log.Errorf("Error reading slab info: %v", err)
+		return err
+	}
+
+	now := time.Now()
+	for name, size := range caches {
+		trend, ok := trends[name]
+		if !ok {
+			trend = utils.NewTrend(trendInterval, trendWindow)
+			trends[name] = trend
+		}
+		trend.Add(now, float64(size)/1024)
+	}
+	for name := range trends {
+		if _, ok := caches[name]; !ok {
+			delete(trends, name)
+		}
+	}
+
+	flagged := make(map[string]struct{})
+	for name, trend := range trends {
+		growth := trend.Growth()
+		if !growth.Monotonic || growth.Rate < growthRate {
+			continue
+		}
+		flagged[name] = struct{}{}
+		if _, ok := growing[name]; !ok {
+			log.Warningf("Slab cache %v is growing by %.2fMB per minute", name, growth.Rate)
+		}
+	}
+	growing = flagged
+
+	excess, err := Excess()
+	if err != nil {
+		log.Errorf("Error getting unreclaimable slab memory: %v", err)
+		return err
+	}
+	if excess == 0 {
+		exceeded = false
+		return nil
+	}
+	if exceeded {
+		return nil
+	}
+	exceeded = true
+
+	log.Warningf("Unreclaimable slab memory exceeds the allowed fraction of memory by %vMB", excess)
+	utils.LogToKernel("ORK: unreclaimable slab memory exceeds the allowed fraction of memory by %vMB\n", excess)
+	utils.LogEvent(utils.KernelMemoryLeak, "slab", utils.Warning)
+	for name := range growing {
+		utils.LogEvent(utils.KernelMemoryLeak, name, utils.Warning)
+	}
+	return nil
+}
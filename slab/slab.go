@@ -0,0 +1,79 @@
+package slab
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zero-os/0-ork/host"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// readSlabInfo returns the memory in kilo byte used by each slab cache as reported by /proc/slabinfo
+func readSlabInfo() (map[string]uint64, error) {
+	contents, err := host.ReadFile("/proc/slabinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	caches := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		// lines are formatted as: name <active_objs> <num_objs> <objsize> ...
+		// the first two lines are the version and the header
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || strings.HasPrefix(fields[0], "#") || fields[0] == "slabinfo" {
+			continue
+		}
+		objects, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing objects of slab cache %v: %v", fields[0], err)
+		}
+		size, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing object size of slab cache %v: %v", fields[0], err)
+		}
+		caches[fields[0]] = objects * size / 1024
+	}
+	return caches, scanner.Err()
+}
+
+// readMeminfo returns the values in kilo byte of /proc/meminfo and fails if one of keys is missing
+func readMeminfo(keys ...string) (map[string]uint64, error) {
+	values, err := host.Meminfo()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if _, ok := values[key]; !ok {
+			return nil, fmt.Errorf("%v is missing from /proc/meminfo", key)
+		}
+	}
+	return values, nil
+}
+
+// Unreclaimable returns the unreclaimable slab memory and the total memory of the host in MB
+func Unreclaimable() (uint64, uint64, error) {
+	values, err := readMeminfo("SUnreclaim", "MemTotal")
+	if err != nil {
+		return 0, 0, err
+	}
+	return values["SUnreclaim"] / 1024, values["MemTotal"] / 1024, nil //convert kilo byte to mega byte
+}
+
+// Excess returns the unreclaimable slab memory in MB above the configured fraction of the total memory.
+// This memory is held by the kernel and can't be recovered by killing user processes.
+func Excess() (uint64, error) {
+	unreclaimable, total, err := Unreclaimable()
+	if err != nil {
+		return 0, err
+	}
+	allowed := uint64(float64(total) * utils.SlabFraction())
+	if unreclaimable <= allowed {
+		return 0, nil
+	}
+	return unreclaimable - allowed, nil
+}
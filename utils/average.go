@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"math"
+	"time"
+)
+
+// Average is an exponentially weighted moving average of samples taken at irregular intervals. The weight of a
+// sample depends on the time elapsed since the previous one so that the average doesn't depend on how often
+// it is sampled.
+type Average struct {
+	decay  float64 // decay is the weight of a sample taken one second after the previous one
+	warmup int
+	count  int
+	value  float64
+	last   time.Time
+}
+
+// NewAverage returns an Average of samples with an average age of age. Like the moving averages of
+// github.com/VividCortex/ewma it is zero until warmup samples were added and starts from their mean.
+func NewAverage(age time.Duration, warmup int) *Average {
+	return &Average{
+		decay:  2 / (age.Seconds() + 1),
+		warmup: warmup,
+	}
+}
+
+// Add adds the sample value taken at now, now must be read from time.Now
+func (a *Average) Add(now time.Time, value float64) {
+	elapsed := now.Sub(a.last)
+	a.last = now
+
+	switch {
+	case a.count < a.warmup:
+		a.count++
+		a.value += value
+		if a.count == a.warmup {
+			a.value /= float64(a.warmup)
+		}
+	case a.count == 0:
+		a.count++
+		a.value = value
+	default:
+		a.count++
+		if elapsed <= 0 {
+			return
+		}
+		weight := 1 - math.Pow(1-a.decay, elapsed.Seconds())
+		a.value += weight * (value - a.value)
+	}
+}
+
+// Set sets the average to value as if it was sampled at now and ends the warmup
+func (a *Average) Set(now time.Time, value float64) {
+	a.value = value
+	a.last = now
+	if a.count < a.warmup {
+		a.count = a.warmup
+	}
+	if a.count == 0 {
+		a.count = 1
+	}
+}
+
+// Value returns the current average
+func (a *Average) Value() float64 {
+	if a.count < a.warmup {
+		return 0
+	}
+	return a.value
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestAverageInterval checks that a rate sampled at different intervals gives the same average 30 seconds
+// after it changed
+func TestAverageInterval(t *testing.T) {
+	start := time.Now()
+	var averages []float64
+	for _, interval := range []time.Duration{time.Second, 3 * time.Second, 250 * time.Millisecond} {
+		counter := NewRate(64)
+		average := NewAverage(60*time.Second, 10)
+		var value uint64
+		for now := start; now.Sub(start) <= 5*time.Minute+30*time.Second; now = now.Add(interval) {
+			// the counter grows by 100 per second for 5 minutes and by 1000 per second after
+			if now.Sub(start) < 5*time.Minute {
+				value += uint64(100 * interval.Seconds())
+			} else {
+				value += uint64(1000 * interval.Seconds())
+			}
+			if rate, ok := counter.Add(now, value); ok {
+				average.Add(now, rate)
+			}
+			if now.Sub(start) == 5*time.Minute-interval && math.Abs(average.Value()-100) > 0.01 {
+				t.Errorf("interval %v: expected an average of 100, got %v", interval, average.Value())
+			}
+		}
+		averages = append(averages, average.Value())
+	}
+
+	for _, average := range averages[1:] {
+		if math.Abs(average-averages[0])/averages[0] > 0.05 {
+			t.Errorf("expected the averages to be independent of the interval, got %v", averages)
+		}
+	}
+}
+
+func TestAverageWarmup(t *testing.T) {
+	average := NewAverage(30*time.Second, 3)
+	now := time.Now()
+	for i, value := range []float64{1, 2} {
+		average.Add(now.Add(time.Duration(i)*time.Second), value)
+		if average.Value() != 0 {
+			t.Fatalf("expected the average to be zero during the warmup, got %v", average.Value())
+		}
+	}
+	average.Add(now.Add(2*time.Second), 3)
+	if average.Value() != 2 {
+		t.Errorf("expected the average to start from the mean of the warmup samples, got %v", average.Value())
+	}
+}
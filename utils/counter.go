@@ -0,0 +1,68 @@
+package utils
+
+import "time"
+
+// Counter computes the increments of a monotonic counter that may wrap around at its width or be reset,
+// like the statistics of a nic that is recreated or the cpu time of a process that reused a pid.
+type Counter struct {
+	mask   uint64
+	last   uint64
+	primed bool
+}
+
+// NewCounter returns a Counter for a counter of width bits
+func NewCounter(width uint) *Counter {
+	mask := ^uint64(0)
+	if width < 64 {
+		mask = 1<<width - 1
+	}
+	return &Counter{mask: mask}
+}
+
+// Add records value and returns the increment since the previous value. It returns false if there is no previous
+// value or if the counter was reset, the sample is discarded then. A decrease is taken as a wrap around if the
+// increment it gives is less than half the range of the counter and as a reset otherwise.
+func (c *Counter) Add(value uint64) (uint64, bool) {
+	value &= c.mask
+	last, primed := c.last, c.primed
+	c.last, c.primed = value, true
+	if !primed {
+		return 0, false
+	}
+
+	delta := (value - last) & c.mask
+	if value < last && delta > c.mask/2 {
+		log.Debugf("Counter was reset from %v to %v, discarding sample", last, value)
+		return 0, false
+	}
+	return delta, true
+}
+
+// Rate computes the rate per second of a counter sampled at irregular intervals
+type Rate struct {
+	counter *Counter
+	last    time.Time
+}
+
+// NewRate returns a Rate for a counter of width bits
+func NewRate(width uint) *Rate {
+	return &Rate{counter: NewCounter(width)}
+}
+
+// Add records the value of the counter at now and returns its rate per second since the previous sample.
+// now must be read from time.Now so that the elapsed time is measured on the monotonic clock. It returns false
+// if there is no previous sample or if the counter was reset.
+func (r *Rate) Add(now time.Time, value uint64) (float64, bool) {
+	delta, ok := r.counter.Add(value)
+	last := r.last
+	r.last = now
+	if !ok {
+		return 0, false
+	}
+
+	elapsed := now.Sub(last)
+	if elapsed <= 0 {
+		return 0, false
+	}
+	return float64(delta) / elapsed.Seconds(), true
+}
@@ -0,0 +1,29 @@
+package utils
+
+import "testing"
+
+func TestCounter(t *testing.T) {
+	cases := []struct {
+		name   string
+		width  uint
+		values []uint64
+		deltas []uint64
+		ok     []bool
+	}{
+		{"increments", 64, []uint64{10, 15, 15, 40}, []uint64{0, 5, 0, 25}, []bool{false, true, true, true}},
+		{"reset", 64, []uint64{1000000, 100, 150}, []uint64{0, 0, 50}, []bool{false, false, true}},
+		{"wrap 32 bits", 32, []uint64{1<<32 - 100, 50}, []uint64{0, 150}, []bool{false, true}},
+		{"reset 32 bits", 32, []uint64{1 << 20, 10}, []uint64{0, 0}, []bool{false, false}},
+		{"wrap 64 bits", 64, []uint64{^uint64(0) - 9, 10}, []uint64{0, 20}, []bool{false, true}},
+	}
+
+	for _, c := range cases {
+		counter := NewCounter(c.width)
+		for i, value := range c.values {
+			delta, ok := counter.Add(value)
+			if delta != c.deltas[i] || ok != c.ok[i] {
+				t.Errorf("%v: sample %v: expected %v %v, got %v %v", c.name, i, c.deltas[i], c.ok[i], delta, ok)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/zero-os/0-ork/host"
+)
+
+// kmsgBufferSize is the size of the buffer messages to the kernel log are formatted in
+const kmsgBufferSize = 1024
+
+var kmsg *os.File
+var kmsgLock sync.Mutex
+var kmsgBuffer = bytes.NewBuffer(make([]byte, 0, kmsgBufferSize))
+
+// OpenKernelLog opens /dev/kmsg once for all so that logging to the kernel doesn't have to open it
+// when the host is out of resources.
+func OpenKernelLog() error {
+	kmsgLock.Lock()
+	defer kmsgLock.Unlock()
+
+	f, err := os.OpenFile("/dev/kmsg", os.O_WRONLY|os.O_APPEND, 0544)
+	if err != nil {
+		return err
+	}
+	kmsg = f
+	return nil
+}
+
+// LogToKernel formats a message in a preallocated buffer and writes it to the kernel log. What is avoided is opening
+// /dev/kmsg and growing a buffer for each message, the arguments still allocate: passing them boxes the values that
+// don't fit in an interface, like large integers and slices, and formatting a value through its String method
+// allocates the returned string. Lists like the victims of a kill should implement fmt.Formatter to be written
+// to the buffer without building a string.
+func LogToKernel(message string, a ...interface{}) {
+	kmsgLock.Lock()
+	defer kmsgLock.Unlock()
+
+	kmsgBuffer.Reset()
+	fmt.Fprintf(kmsgBuffer, message, a...)
+
+	// Without the kernel log opened by OpenKernelLog each message is a write to /dev/kmsg of the host
+	var err error
+	if kmsg != nil {
+		_, err = kmsg.Write(kmsgBuffer.Bytes())
+	} else {
+		err = host.WriteFile("/dev/kmsg", kmsgBuffer.Bytes())
+	}
+	if err != nil {
+		log.Errorf("Error writing to /dev/kmsg: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zero-os/0-ork/host"
+)
+
+// basePageSize is the size in kB of the normal pages
+var basePageSize = uint64(os.Getpagesize() / 1024)
+
+// NodeMemory returns the memory in MB a process has on each NUMA node as reported by /proc/<pid>/numa_maps.
+// Hugetlb mappings are left out since their pages are reserved in the hugepages pools of the nodes and don't
+// take from the memory available on them.
+func NodeMemory(pid int32) (map[int]uint64, error) {
+	contents, err := host.ReadFile(fmt.Sprintf("/proc/%v/numa_maps", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	// pages per node are counted in kilo bytes
+	nodes := make(map[int]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		pageSize := basePageSize
+		huge := false
+		pages := make(map[int]uint64)
+		for _, field := range strings.Fields(scanner.Text()) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				huge = huge || field == "huge"
+				continue
+			}
+			if kv[0] == "kernelpagesize_kB" {
+				if size, err := strconv.ParseUint(kv[1], 10, 64); err == nil {
+					pageSize = size
+				}
+				continue
+			}
+			if !strings.HasPrefix(kv[0], "N") {
+				continue
+			}
+			node, err := strconv.Atoi(kv[0][1:])
+			if err != nil {
+				continue
+			}
+			count, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			pages[node] += count
+		}
+		if huge || pageSize > basePageSize {
+			continue
+		}
+		for node, count := range pages {
+			nodes[node] += count * pageSize
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for node, kb := range nodes {
+		nodes[node] = kb / 1024 //convert kilo byte to mega byte
+	}
+	return nodes, nil
+}
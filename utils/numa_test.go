@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zero-os/0-ork/host"
+)
+
+func TestNodeMemory(t *testing.T) {
+	fake := host.NewFake()
+	defer host.Use(host.Use(fake))
+	fake.Set("/proc/1/numa_maps", "00400000 default file=/usr/bin/qemu mapped=2048 N0=1536 N1=512 kernelpagesize_kB=4\n"+
+		"7f0000000000 default anon=1024 dirty=1024 N1=1024 kernelpagesize_kB=4\n"+
+		// the guest memory backed by hugepages doesn't take from the memory available on the nodes
+		"7f4000000000 bind:0 file=/dev/hugepages/qemu huge dirty=1024 N0=1024 kernelpagesize_kB=2048\n"+
+		"7f8000000000 bind:1 anon=4 dirty=4 N1=4 kernelpagesize_kB=1048576\n")
+
+	nodes, err := NodeMemory(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (map[int]uint64{0: 6, 1: 6}); !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("expected %v, got %v", expected, nodes)
+	}
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Scheduling policies as defined in linux/sched.h
+const SchedOther = 0
+const SchedFIFO = 1
+const SchedRR = 2
+
+// SchedResetOnFork is or'ed into a policy so that the children of the task don't inherit its policy and priority
+const SchedResetOnFork = 0x40000000
+
+// I/O scheduling classes as defined in linux/ioprio.h
+const IoprioClassNone = 0
+const IoprioClassRT = 1
+const IoprioClassBE = 2
+const IoprioClassIdle = 3
+
+const ioprioWhoProcess = 1
+const ioprioClassShift = 13
+
+// realtimePriority is the realtime priority of the ORK monitors, it is kept low so that it doesn't
+// compete with the realtime tasks of the host but still runs before any normal task.
+const realtimePriority = 1
+
+type schedParam struct {
+	priority int32
+}
+
+// SetScheduler sets the scheduling policy and priority of the task with the given tid, zero being the calling thread
+func SetScheduler(tid int, policy int, priority int) error {
+	param := schedParam{int32(priority)}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, uintptr(tid), uintptr(policy), uintptr(unsafe.Pointer(&param)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// SetIoPriority sets the I/O scheduling class and level of the task with the given tid
+func SetIoPriority(tid int, class int, level int) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(class<<ioprioClassShift|level))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// GetIoPriority returns the I/O scheduling class and level of the task with the given tid
+func GetIoPriority(tid int) (int, int, error) {
+	prio, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(tid), 0)
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	return int(prio) >> ioprioClassShift, int(prio) & (1<<ioprioClassShift - 1), nil
+}
+
+// LockRealtime locks the calling goroutine to its thread and runs the thread with a low realtime priority so
+// that it keeps running when a realtime task is spinning on a cpu. The processes forked from the thread, such as
+// the commands run by the monitors, are reset to the normal policy so that they can't starve the host.
+func LockRealtime() {
+	runtime.LockOSThread()
+	if err := SetScheduler(0, SchedFIFO|SchedResetOnFork, realtimePriority); err != nil {
+		log.Errorf("Error setting realtime priority: %v", err)
+	}
+}
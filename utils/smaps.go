@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/zero-os/0-ork/host"
+)
+
+// ReadSmapsRollup returns the memory accounting of a process in MB as reported by /proc/<pid>/smaps_rollup.
+// PSS accounts shared pages proportionally to the number of processes mapping them and USS only
+// accounts the pages that are private to the process, that is the memory reclaimed by killing it.
+// Hugetlb pages are not accounted in RSS, PSS and USS and are reported separately. SwapPSS is the swap
+// counterpart of PSS, it is the whole swap usage on kernels that don't report SwapPss.
+func ReadSmapsRollup(pid int32) (MemoryUsage, error) {
+	contents, err := host.ReadFile(fmt.Sprintf("/proc/%v/smaps_rollup", pid))
+	if err != nil {
+		return MemoryUsage{}, err
+	}
+
+	// values in smaps_rollup are in kilo byte
+	var rss, pss, uss, swap, swapPss, hugePages uint64
+	hasSwapPss := false
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		fields := bytes.Fields(scanner.Bytes())
+		if len(fields) != 3 {
+			continue
+		}
+		value, err := strconv.ParseUint(string(fields[1]), 10, 64)
+		if err != nil {
+			return MemoryUsage{}, err
+		}
+
+		switch string(fields[0]) {
+		case "Rss:":
+			rss = value
+		case "Pss:":
+			pss = value
+		case "Private_Clean:", "Private_Dirty:":
+			uss += value
+		case "Swap:":
+			swap = value
+		case "SwapPss:":
+			swapPss, hasSwapPss = value, true
+		case "Private_Hugetlb:", "Shared_Hugetlb:":
+			hugePages += value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return MemoryUsage{}, err
+	}
+	if !hasSwapPss {
+		swapPss = swap
+	}
+
+	return MemoryUsage{
+		RSS:       rss / 1024, //convert kilo byte to mega byte
+		PSS:       pss / 1024,
+		USS:       uss / 1024,
+		Swap:      swap / 1024,
+		SwapPSS:   swapPss / 1024,
+		HugePages: hugePages / 1024,
+	}, nil
+}
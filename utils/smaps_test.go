@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/zero-os/0-ork/host"
+)
+
+func TestReadSmapsRollup(t *testing.T) {
+	fake := host.NewFake()
+	fake.Set("/proc/1/smaps_rollup", "Rss: 20480 kB\nPss: 12288 kB\nPrivate_Clean: 2048 kB\nPrivate_Dirty: 8192 kB\n"+
+		"Swap: 4096 kB\nSwapPss: 1024 kB\nPrivate_Hugetlb: 2048 kB\n")
+	// kernels before 4.3 don't report SwapPss
+	fake.Set("/proc/2/smaps_rollup", "Rss: 20480 kB\nPss: 12288 kB\nSwap: 4096 kB\n")
+	defer host.Use(host.Use(fake))
+
+	usage, err := ReadSmapsRollup(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (MemoryUsage{RSS: 20, PSS: 12, USS: 10, Swap: 4, SwapPSS: 1, HugePages: 2}); usage != expected {
+		t.Errorf("expected %+v, got %+v", expected, usage)
+	}
+
+	usage, err = ReadSmapsRollup(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.SwapPSS != usage.Swap {
+		t.Errorf("expected the whole swap usage without SwapPss, got %+v", usage)
+	}
+}
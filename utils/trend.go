@@ -0,0 +1,85 @@
+package utils
+
+import "time"
+
+// Growth describes how a value evolved over the window of a Trend
+type Growth struct {
+	Rate      float64 // Rate is the growth of the value per minute
+	Monotonic bool    // Monotonic is true if the value never decreased over the whole window
+}
+
+type trendSample struct {
+	time  time.Time
+	value float64
+}
+
+// Trend keeps samples of a value taken at a fixed interval over a window of time
+type Trend struct {
+	interval time.Duration
+	size     int
+	samples  []trendSample
+}
+
+// NewTrend returns a Trend that keeps a sample every interval for window
+func NewTrend(interval time.Duration, window time.Duration) *Trend {
+	size := int(window/interval) + 1
+	return &Trend{
+		interval: interval,
+		size:     size,
+		samples:  make([]trendSample, 0, size),
+	}
+}
+
+// Add records value if interval has elapsed since the last recorded sample
+func (t *Trend) Add(now time.Time, value float64) {
+	if n := len(t.samples); n > 0 && now.Sub(t.samples[n-1].time) < t.interval {
+		return
+	}
+	if len(t.samples) == t.size {
+		copy(t.samples, t.samples[1:])
+		t.samples = t.samples[:t.size-1]
+	}
+	t.samples = append(t.samples, trendSample{now, value})
+}
+
+// Reset discards all the samples
+func (t *Trend) Reset() {
+	t.samples = t.samples[:0]
+}
+
+// Full returns true if the trend has samples for its whole window
+func (t *Trend) Full() bool {
+	return len(t.samples) == t.size
+}
+
+// Growth returns the growth rate of the value computed by a least squares fit over the samples.
+// Monotonic is only reported once the trend is full.
+func (t *Trend) Growth() Growth {
+	n := float64(len(t.samples))
+	if n < 2 {
+		return Growth{}
+	}
+
+	start := t.samples[0].time
+	var sumX, sumY, sumXY, sumXX float64
+	monotonic := t.Full()
+	for i, sample := range t.samples {
+		x := sample.time.Sub(start).Minutes()
+		sumX += x
+		sumY += sample.value
+		sumXY += x * sample.value
+		sumXX += x * x
+		if i > 0 && sample.value < t.samples[i-1].value {
+			monotonic = false
+		}
+	}
+
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return Growth{}
+	}
+	return Growth{
+		Rate:      (n*sumXY - sumX*sumY) / denominator,
+		Monotonic: monotonic,
+	}
+}
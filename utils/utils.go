@@ -1,15 +1,18 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
-	"fmt"
 	"github.com/google/shlex"
 	"github.com/op/go-logging"
-	"io/ioutil"
+	"github.com/zero-os/0-ork/host"
 	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var log = logging.MustGetLogger("ORK")
@@ -18,6 +21,25 @@ type NetworkUsage struct {
 	Rxb, Txb, Rxp, Txp float64
 }
 
+// MemoryUsage holds the memory accounting of an activity in MB. SwapPSS accounts the swapped out shared pages
+// proportionally like PSS while Swap accounts all of them.
+type MemoryUsage struct {
+	RSS, PSS, USS, Swap, SwapPSS, HugePages uint64
+}
+
+// SwapUsage holds the swap usage of an activity in MB and its rate of major page faults per second
+type SwapUsage struct {
+	Used   uint64
+	Faults float64
+}
+
+type memoryMetric string
+
+// Metrics that can be used to rank activities by memory consumption
+const RSS memoryMetric = "rss"
+const PSS memoryMetric = "pss"
+const USS memoryMetric = "uss"
+
 type state string
 
 const Success state = "SUCCESS"
@@ -29,6 +51,20 @@ type event string
 const NicShutdown event = "NIC_SHUTDOWN"
 const Quarantine event = "VM_QUARANTINE"
 const UnQuarantine event = "VM_UNQUARANTINE"
+const MemoryLeak event = "MEMORY_LEAK"
+const MemoryExhaustion event = "MEMORY_EXHAUSTION"
+const SwapThrashing event = "SWAP_THRASHING"
+const MemoryReclaim event = "MEMORY_RECLAIM"
+const KernelMemoryLeak event = "KERNEL_MEMORY_LEAK"
+const HugePagesExhaustion event = "HUGEPAGES_EXHAUSTION"
+const ForkBomb event = "FORK_BOMB"
+const FDExhaustion event = "FD_EXHAUSTION"
+const InodeExhaustion event = "INODE_EXHAUSTION"
+const HungTasks event = "HUNG_TASKS"
+const RealtimeDemote event = "REALTIME_DEMOTE"
+const CPUThrottle event = "CPU_THROTTLE"
+const CPUUnThrottle event = "CPU_UNTHROTTLE"
+const LoopStalled event = "LOOP_STALLED"
 
 type message struct {
 	Event event  `json:"event"`
@@ -40,85 +76,149 @@ type kernelOptions map[string][]string
 
 var kernelArgs kernelOptions
 var dev bool = false
-var monitorMem bool = true
-var monitorCPU bool = true
-var monitorNetwork bool = true
-var monitorFairUsage bool = true
+var metric memoryMetric = PSS
+
+// defaultLeakRate is the leak rate in MB per minute and defaultSlabFraction the slab fraction used when they are
+// not set in the kernel parameters
+const defaultLeakRate float64 = 10
+const defaultSlabFraction float64 = 0.2
+
+var leakRate float64 = defaultLeakRate
+var leakDeadline time.Duration
+var slabFraction float64 = defaultSlabFraction
+var hugePagesKill bool = false
+var hungKill bool = false
+
+// options holds the ork kernel arguments of the form ork=<key>=<value>
+var options = map[string]string{}
+
+// disabled holds the monitors disabled with kernel arguments of the form ork=no<monitor>
+var disabled = map[string]bool{}
 
 func init() {
+	LoadOptions()
+}
+
+// LoadOptions reads the ork options from the kernel command line of the host, it is called again by tests
+// that run ORK against a fake host.
+func LoadOptions() {
 	kernelArgs := getKernelOptions()
 
 	log.Debugf("Kernel Args: %v", kernelArgs)
 
+	options = map[string]string{}
+	disabled = map[string]bool{}
+	dev, hungKill, hugePagesKill = false, false, false
+	metric = PSS
+
 	if args, ok := kernelArgs["ork"]; ok {
 		for _, arg := range args {
-			if match, err := regexp.MatchString(`development`, arg); err != nil {
-				log.Error(err)
-				os.Exit(1)
-			} else if match {
-				dev = true
+			if kv := strings.SplitN(arg, "=", 2); len(kv) == 2 {
+				options[kv[0]] = kv[1]
+			} else if strings.HasPrefix(arg, "no") {
+				disabled[strings.TrimPrefix(arg, "no")] = true
 			}
 
-			if match, err := regexp.MatchString(`nomem`, arg); err != nil {
+			if match, err := regexp.MatchString(`development`, arg); err != nil {
 				log.Error(err)
 				os.Exit(1)
 			} else if match {
-				monitorMem = false
+				dev = true
 			}
 
-			if match, err := regexp.MatchString(`nocpu`, arg); err != nil {
+			if match, err := regexp.MatchString(`hungkill`, arg); err != nil {
 				log.Error(err)
 				os.Exit(1)
 			} else if match {
-				monitorCPU = false
+				hungKill = true
 			}
 
-			if match, err := regexp.MatchString(`nonetwork`, arg); err != nil {
+			if match, err := regexp.MatchString(`hugepageskill`, arg); err != nil {
 				log.Error(err)
 				os.Exit(1)
 			} else if match {
-				monitorNetwork = false
+				hugePagesKill = true
 			}
+		}
+	}
 
-			if match, err := regexp.MatchString(`nofairusage`, arg); err != nil {
-				log.Error(err)
-				os.Exit(1)
-			} else if match {
-				monitorFairUsage = false
-			}
+	if value, ok := options["memmetric"]; ok {
+		switch m := memoryMetric(value); m {
+		case RSS, PSS, USS:
+			metric = m
+		default:
+			log.Errorf("Unknown memory metric %v, using %v", value, metric)
 		}
 	}
-}
 
-func MonitorCPU() bool {
-	return monitorCPU
+	leakRate = floatOption("leakrate", defaultLeakRate)
+	leakDeadline = time.Duration(floatOption("leakdeadline", 0) * float64(time.Minute))
+	slabFraction = floatOption("slabfraction", defaultSlabFraction)
 }
 
-func MonitorMem() bool {
-	return monitorMem
+// floatOption returns the value of the option name parsed as a float or def if it is not set or invalid
+func floatOption(name string, def float64) float64 {
+	value, ok := options[name]
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Errorf("Invalid value %v for option %v, using %v", value, name, def)
+		return def
+	}
+	return f
 }
 
-func MonitorNetwork() bool {
-	return monitorNetwork
+// MonitorEnabled returns false if the monitor name is disabled in the kernel parameters
+func MonitorEnabled(name string) bool {
+	return !disabled[name]
 }
 
-func MonitorFairUsage() bool {
-	return monitorFairUsage
+// MonitorInterval returns the interval in seconds set for the monitor name with ork=<name>interval=<value>
+// or def if it is not set
+func MonitorInterval(name string, def time.Duration) time.Duration {
+	interval := floatOption(name+"interval", def.Seconds())
+	if interval <= 0 {
+		log.Errorf("Invalid interval %v for monitor %v, using %v", interval, name, def)
+		return def
+	}
+	return time.Duration(interval * float64(time.Second))
 }
 
 func Development() bool {
 	return dev
 }
 
-// delta is a small closure over the counters, returning the delta against previous
-// first = initial value
-func Delta(first uint64) func(uint64) uint64 {
-	keep := first
-	return func(delta uint64) uint64 {
-		v := delta - keep
-		keep = delta
-		return v
-	}
+// MemoryMetric returns the metric used to rank activities by memory consumption
+func MemoryMetric() memoryMetric {
+	return metric
+}
+
+// LeakRate returns the memory growth rate in MB per minute above which an activity is considered leaking
+func LeakRate() float64 {
+	return leakRate
+}
+
+// HugePagesKill returns true if ORK should destroy vms when a hugepages pool is exhausted
+func HugePagesKill() bool {
+	return hugePagesKill
+}
+
+// HungKill returns true if ORK should kill the process trees of hung tasks
+func HungKill() bool {
+	return hungKill
+}
+
+// SlabFraction returns the fraction of the total memory the unreclaimable slab memory is allowed to use
+func SlabFraction() float64 {
+	return slabFraction
+}
+
+// LeakDeadline returns the forecasted time to memory exhaustion under which a leaking activity is killed.
+// Leaking activities are never killed if it is zero.
+func LeakDeadline() time.Duration {
+	return leakDeadline
 }
 
 // Sort is a wrapper for the sort.Sort function that recovers
@@ -135,39 +235,34 @@ func Sort(i sort.Interface) error {
 	return err
 }
 
-func LogToKernel(message string, a ...interface{}) {
+// eventBufferSize is the size of the buffer events are formatted in
+const eventBufferSize = 512
 
-	f, err := os.OpenFile("/dev/kmsg", os.O_WRONLY|os.O_APPEND, 0544)
-
-	if err != nil {
-		log.Error("Error opening /dev/kmsg")
-		return
-	}
-
-	defer f.Close()
-
-	if _, err := f.WriteString(fmt.Sprintf(message, a...)); err != nil {
-		log.Errorf("Error writing to /dev/kmsg: %v", err)
-	}
-}
+var eventLock sync.Mutex
+var eventBuffer = bytes.NewBuffer(make([]byte, 0, eventBufferSize))
+var eventEncoder = json.NewEncoder(eventBuffer)
 
+// LogEvent writes an event about an action of ORK to stdout. The event is encoded by the same encoder in the same
+// buffer every time, which keeps the allocations of logging an action low but doesn't avoid them.
 func LogEvent(event event, name string, state state) {
 	message := message{
 		event,
 		name,
 		state,
 	}
-	msg, err := json.Marshal(&message)
-	if err != nil {
-		return
-	}
 
-	fmt.Println(fmt.Sprintf("20::%v", string(msg)))
-	return
+	eventLock.Lock()
+	defer eventLock.Unlock()
 
+	eventBuffer.Reset()
+	eventBuffer.WriteString("20::")
+	if err := eventEncoder.Encode(&message); err != nil {
+		return
+	}
+	os.Stdout.Write(eventBuffer.Bytes())
 }
 
-//InList checks if x is in l
+// InList checks if x is in l
 func InList(x string, l []string) bool {
 	for i := 0; i < len(l); i++ {
 		if l[i] == x {
@@ -194,7 +289,7 @@ func parseKernelOptions(content string) kernelOptions {
 }
 
 func getKernelOptions() kernelOptions {
-	content, err := ioutil.ReadFile("/proc/cmdline")
+	content, err := host.ReadFile("/proc/cmdline")
 	if err != nil {
 		log.Warning("Failed to read /proc/cmdline", err)
 		return kernelOptions{}
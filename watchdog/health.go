@@ -0,0 +1,34 @@
+package watchdog
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type health struct {
+	Alive   bool                 `json:"alive"`
+	Stalled []string             `json:"stalled"`
+	Beats   map[string]time.Time `json:"beats"`
+}
+
+// ServeHealth serves the liveness of the loops on /health at address, the status code is 503
+// if any loop is stalled.
+func (w *Watchdog) ServeHealth(address string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(rw http.ResponseWriter, r *http.Request) {
+		stalled := w.Stalled()
+		h := health{
+			Alive:   len(stalled) == 0,
+			Stalled: stalled,
+			Beats:   w.Beats(),
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		if !h.Alive {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(rw).Encode(&h)
+	})
+	return http.ListenAndServe(address, mux)
+}
@@ -0,0 +1,26 @@
+package watchdog
+
+import (
+	"net"
+	"os"
+)
+
+// notify sends state to the service manager through the socket in NOTIFY_SOCKET, it does nothing if ORK
+// is not run by a service manager supporting it.
+func notify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Errorf("Error connecting to notify socket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Errorf("Error notifying %v: %v", state, err)
+	}
+}
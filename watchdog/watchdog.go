@@ -0,0 +1,185 @@
+// Package watchdog supervises the ORK loops and restarts the ones that stop making progress
+package watchdog
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/zero-os/0-ork/utils"
+)
+
+// maxRestarts is the number of times a stalled loop is restarted before ORK aborts
+const maxRestarts = 3
+
+// checkInterval is the interval at which the loops are checked
+const checkInterval = time.Second
+
+// recoveryTime is the time a restarted loop has to keep beating before its restarts are forgotten
+const recoveryTime = 10 * time.Minute
+
+var log = logging.MustGetLogger("ORK")
+
+// Beat is called by a loop on each iteration, it returns false if the loop was replaced and should exit
+type Beat func() bool
+
+// Run is the body of a loop. ctx is cancelled when the loop is replaced, so a loop must check it before
+// taking any action since a stalled generation may resume after it was replaced. The replacement only runs
+// once the stalled generation returned so that the state of a loop is never accessed by two generations.
+type Run func(ctx context.Context, beat Beat)
+
+type loop struct {
+	name       string
+	deadline   time.Duration
+	ctx        context.Context
+	run        Run
+	cancel     context.CancelFunc
+	beat       time.Time
+	generation int
+	restarts   int
+	restarted  time.Time
+	running    sync.Mutex // held by the generation that runs
+}
+
+// Watchdog keeps track of the last beat of each loop
+type Watchdog struct {
+	lock  sync.Mutex
+	loops map[string]*loop
+}
+
+func New() *Watchdog {
+	return &Watchdog{
+		loops: make(map[string]*loop),
+	}
+}
+
+// Go starts run in a goroutine as the loop name until ctx is done. The loop is considered stalled if it doesn't
+// beat within deadline.
+func (w *Watchdog) Go(ctx context.Context, name string, deadline time.Duration, run Run) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	l := &loop{
+		name:     name,
+		deadline: deadline,
+		ctx:      ctx,
+		run:      run,
+		beat:     time.Now(),
+	}
+	w.loops[name] = l
+	w.start(l)
+}
+
+// start runs a new generation of the loop. The context of the previous generation is cancelled so that it
+// doesn't act anymore and it exits on its next beat, the new generation waits for it to return.
+// w.lock must be held.
+func (w *Watchdog) start(l *loop) {
+	if l.cancel != nil {
+		l.cancel()
+	}
+	ctx, cancel := context.WithCancel(l.ctx)
+	l.cancel = cancel
+
+	generation := l.generation
+	beat := func() bool {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+
+		if l.generation != generation {
+			log.Infof("Loop %v was replaced, exiting", l.name)
+			return false
+		}
+		l.beat = time.Now()
+		return true
+	}
+	go func() {
+		l.running.Lock()
+		defer l.running.Unlock()
+		// the generation may have been replaced while it was waiting
+		if ctx.Err() != nil {
+			return
+		}
+		l.run(ctx, beat)
+	}()
+}
+
+// Stalled returns the names of the loops that didn't beat within their deadline
+func (w *Watchdog) Stalled() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var stalled []string
+	for name, l := range w.loops {
+		if time.Since(l.beat) > l.deadline {
+			stalled = append(stalled, name)
+		}
+	}
+	return stalled
+}
+
+// Beats returns the time of the last beat of each loop
+func (w *Watchdog) Beats() map[string]time.Time {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	beats := make(map[string]time.Time, len(w.loops))
+	for name, l := range w.loops {
+		beats[name] = l.beat
+	}
+	return beats
+}
+
+// check restarts the stalled loops and aborts ORK if a loop stalled more than maxRestarts times without
+// recovering for recoveryTime in between. It returns true if all the loops are alive.
+func (w *Watchdog) check() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	alive := true
+	for _, l := range w.loops {
+		stalled := time.Since(l.beat)
+		if stalled <= l.deadline {
+			if l.restarts > 0 && time.Since(l.restarted) >= recoveryTime {
+				log.Infof("Loop %v recovered after %v restarts", l.name, l.restarts)
+				l.restarts = 0
+			}
+			continue
+		}
+		alive = false
+
+		if l.restarts >= maxRestarts {
+			log.Errorf("Loop %v is stalled for %v and was already restarted %v times, aborting", l.name, stalled, l.restarts)
+			utils.LogToKernel("ORK: loop %v is stalled, aborting\n", l.name)
+			utils.LogEvent(utils.LoopStalled, l.name, utils.Error)
+			os.Exit(1)
+		}
+
+		log.Errorf("Loop %v is stalled for %v, restarting it", l.name, stalled)
+		utils.LogToKernel("ORK: loop %v is stalled, restarting it\n", l.name)
+		utils.LogEvent(utils.LoopStalled, l.name, utils.Warning)
+		l.generation++
+		l.restarts++
+		l.restarted = time.Now()
+		l.beat = l.restarted
+		w.start(l)
+	}
+	return alive
+}
+
+// Supervise checks the loops until ctx is done and notifies systemd that ORK is alive as long as all the loops are
+func (w *Watchdog) Supervise(ctx context.Context) {
+	notify("READY=1")
+	for {
+		if w.check() {
+			notify("WATCHDOG=1")
+		}
+		select {
+		case <-ctx.Done():
+			notify("STOPPING=1")
+			return
+		case <-time.After(checkInterval):
+		}
+	}
+}
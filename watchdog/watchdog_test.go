@@ -0,0 +1,93 @@
+package watchdog
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRestartCancelsStalledGeneration(t *testing.T) {
+	w := New()
+	generations := make(chan context.Context, 2)
+	w.Go(context.Background(), "test", 10*time.Millisecond, func(ctx context.Context, beat Beat) {
+		generations <- ctx
+		<-ctx.Done()
+	})
+
+	first := <-generations
+	time.Sleep(20 * time.Millisecond)
+	if w.check() {
+		t.Fatal("expected the loop to be stalled")
+	}
+	if first.Err() == nil {
+		t.Error("expected the context of the stalled generation to be cancelled")
+	}
+	second := <-generations
+	if second.Err() != nil {
+		t.Error("expected the context of the new generation to be alive")
+	}
+	if l := w.loops["test"]; l.restarts != 1 {
+		t.Errorf("expected 1 restart, got %v", l.restarts)
+	}
+}
+
+func TestRestartsAreForgottenAfterRecovery(t *testing.T) {
+	w := New()
+	w.Go(context.Background(), "test", time.Minute, func(ctx context.Context, beat Beat) {})
+
+	l := w.loops["test"]
+	l.restarts = maxRestarts
+	l.restarted = time.Now()
+	if !w.check() || l.restarts != maxRestarts {
+		t.Fatalf("expected the restarts of a loop that just recovered to be kept, got %v", l.restarts)
+	}
+
+	l.restarted = time.Now().Add(-recoveryTime)
+	if !w.check() || l.restarts != 0 {
+		t.Errorf("expected the restarts of a recovered loop to be forgotten, got %v", l.restarts)
+	}
+}
+
+func TestRestartWaitsForStalledGeneration(t *testing.T) {
+	w := New()
+	release := make(chan struct{})
+	started := make(chan int, 2)
+	var running, generations int32
+	w.Go(context.Background(), "test", 10*time.Millisecond, func(ctx context.Context, beat Beat) {
+		if atomic.AddInt32(&running, 1) != 1 {
+			t.Error("expected a single generation to run at a time")
+		}
+		defer atomic.AddInt32(&running, -1)
+		generation := atomic.AddInt32(&generations, 1)
+		started <- int(generation)
+		// the first generation is stuck in a step that doesn't check ctx
+		if generation == 1 {
+			<-release
+		}
+		for beat() {
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	<-started
+	time.Sleep(20 * time.Millisecond)
+	if w.check() {
+		t.Fatal("expected the loop to be stalled")
+	}
+	select {
+	case <-started:
+		t.Fatal("expected the new generation to wait for the stalled one to return")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case generation := <-started:
+		if generation != 2 {
+			t.Errorf("expected the second generation to start, got %v", generation)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the new generation to start once the stalled one returned")
+	}
+}